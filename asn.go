@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MatchASN reports whether ip's autonomous system, per the configured
+// provider, is one of asns.
+func (g *GeoIPService) MatchASN(ip net.IP, asns []uint) bool {
+	info, err := g.provider.LookupASN(ip)
+	if err != nil {
+		return false
+	}
+	for _, asn := range asns {
+		if asn == info.ASN {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupCIDR returns the enclosing network prefix for ip, along with the
+// ASN and organization that announce it. It requires a provider that
+// implements CIDRLookupProvider (true for MaxMind, not for a pure remote
+// API backend).
+func (g *GeoIPService) LookupCIDR(ip net.IP) (*net.IPNet, uint, string, error) {
+	cidrProvider, ok := g.provider.(CIDRLookupProvider)
+	if !ok {
+		return nil, 0, "", fmt.Errorf("configured provider does not support CIDR lookups")
+	}
+
+	network, err := cidrProvider.LookupNetwork(ip)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("CIDR lookup failed: %w", err)
+	}
+
+	info, err := g.provider.LookupASN(ip)
+	if err != nil {
+		return network, 0, "", nil
+	}
+
+	return network, info.ASN, info.Org, nil
+}
+
+// parseASNList parses a comma-separated list of ASNs such as
+// "AS13335,AS15169", tolerating an optional "AS"/"as" prefix on each entry.
+func parseASNList(raw string) ([]uint, error) {
+	parts := strings.Split(raw, ",")
+	asns := make([]uint, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		p = strings.TrimPrefix(strings.ToUpper(p), "AS")
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ASN %q: %w", p, err)
+		}
+		asns = append(asns, uint(n))
+	}
+	return asns, nil
+}
+
+// MatchASNRequest handles GET /asn/match?asn=AS13335,AS15169[&ip=...],
+// reporting whether the given (or caller's) IP belongs to one of the listed
+// autonomous systems.
+func (g *GeoIPService) MatchASNRequest(c *gin.Context) {
+	asns, err := parseASNList(c.Query("asn"))
+	if err != nil || len(asns) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "asn query parameter is required, e.g. ?asn=AS13335,AS15169"})
+		return
+	}
+
+	ipStr := c.Query("ip")
+	if ipStr == "" {
+		ipStr = getClientIP(c, g.trustedProxies, g.ipHeaders, g.trustCFConnectingIP, g.cfStrict, g.cfRanges)
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address format"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ip": ipStr, "match": g.MatchASN(ip, asns)})
+}
+
+// CIDRLookup handles GET /cidr[?ip=...], returning the enclosing network
+// prefix for the given (or caller's) IP along with its ASN/org.
+func (g *GeoIPService) CIDRLookup(c *gin.Context) {
+	ipStr := c.Query("ip")
+	if ipStr == "" {
+		ipStr = getClientIP(c, g.trustedProxies, g.ipHeaders, g.trustCFConnectingIP, g.cfStrict, g.cfRanges)
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address format"})
+		return
+	}
+
+	network, asn, asnOrg, err := g.LookupCIDR(ip)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ip":      ipStr,
+		"cidr":    network.String(),
+		"asn":     asn,
+		"asn_org": asnOrg,
+	})
+}
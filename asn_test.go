@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseASNList(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected []uint
+		wantErr  bool
+	}{
+		{"AS-prefixed", "AS13335,AS15169", []uint{13335, 15169}, false},
+		{"bare numbers with spaces", "13335, 15169", []uint{13335, 15169}, false},
+		{"lowercase as prefix", "as13335", []uint{13335}, false},
+		{"invalid entry", "AS13335,notanumber", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseASNList(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseASNList(%q) = %v, want %v", tt.raw, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxBulkIPs caps how many addresses a single /lookup/bulk request may
+	// contain, so one oversized batch can't pin every mmdb worker.
+	maxBulkIPs = 10000
+
+	// maxBulkBodyBytes bounds how much of the request body we'll buffer
+	// before parsing it as a JSON array or newline-delimited IP list.
+	maxBulkBodyBytes = 4 << 20 // 4MB
+
+	bulkWorkers = 16
+)
+
+// BulkResult is one line of the NDJSON stream returned by /lookup/bulk.
+// IP is always present; either GeoIPResponse's fields are promoted into the
+// line, or Error explains why the lookup failed.
+type BulkResult struct {
+	IP string `json:"ip"`
+	*GeoIPResponse
+	Error string `json:"error,omitempty"`
+}
+
+// BulkLookup handles POST /lookup/bulk: it accepts a JSON array of IPs or a
+// newline-delimited list, fans the lookups across a worker pool, and streams
+// one NDJSON object per IP back to the caller as results become available.
+func (g *GeoIPService) BulkLookup(c *gin.Context) {
+	ips, err := parseBulkIPs(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(ips) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no IP addresses provided"})
+		return
+	}
+	if len(ips) > maxBulkIPs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many IPs: %d exceeds limit of %d", len(ips), maxBulkIPs)})
+		return
+	}
+
+	var fields []string
+	if raw := c.Query("fields"); raw != "" {
+		fields = strings.Split(raw, ",")
+	}
+
+	jobs := make(chan string)
+	results := make(chan BulkResult, bulkWorkers)
+
+	// done fires when the client disconnects mid-stream. Without it, gin's
+	// Stream simply stops calling us (it watches CloseNotify itself) while
+	// the producer and every worker blocked sending into the now-unread
+	// results channel would leak forever; selecting on done here lets them
+	// unwind instead.
+	done := c.Request.Context().Done()
+
+	var wg sync.WaitGroup
+	wg.Add(bulkWorkers)
+	for i := 0; i < bulkWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for ipStr := range jobs {
+				select {
+				case results <- g.bulkLookupOne(ipStr):
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, ip := range ips {
+			select {
+			case jobs <- ip:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return false
+			}
+			line, err := json.Marshal(projectBulkResult(result, fields))
+			if err != nil {
+				return true
+			}
+			w.Write(line)
+			w.Write([]byte("\n"))
+			return true
+		case <-done:
+			return false
+		}
+	})
+}
+
+// bulkLookupOne performs a single IP lookup against the configured
+// provider, returning a result rather than writing an HTTP response so it
+// can run concurrently across the worker pool.
+func (g *GeoIPService) bulkLookupOne(ipStr string) BulkResult {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return BulkResult{IP: ipStr, Error: "invalid IP address format"}
+	}
+
+	response, err := g.provider.Lookup(ip)
+	if err != nil {
+		return BulkResult{IP: ipStr, Error: "failed to lookup IP address"}
+	}
+	response.IP = ipStr
+
+	return BulkResult{IP: ipStr, GeoIPResponse: response}
+}
+
+// parseBulkIPs reads the request body and interprets it as either a JSON
+// array of IP strings or a newline-delimited text list.
+func parseBulkIPs(c *gin.Context) ([]string, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBulkBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var ips []string
+		if err := json.Unmarshal(trimmed, &ips); err != nil {
+			return nil, fmt.Errorf("invalid JSON array body: %w", err)
+		}
+		return ips, nil
+	}
+
+	var ips []string
+	for _, line := range strings.Split(string(trimmed), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			ips = append(ips, line)
+		}
+	}
+	return ips, nil
+}
+
+// bulkFieldSetters maps a `fields=` query value to the GeoIPResponse field
+// it should project out of a BulkResult.
+var bulkFieldSetters = map[string]func(*GeoIPResponse) interface{}{
+	"country":      func(r *GeoIPResponse) interface{} { return r.Country },
+	"country_code": func(r *GeoIPResponse) interface{} { return r.CountryCode },
+	"region":       func(r *GeoIPResponse) interface{} { return r.Region },
+	"region_code":  func(r *GeoIPResponse) interface{} { return r.RegionCode },
+	"city":         func(r *GeoIPResponse) interface{} { return r.City },
+	"postal_code":  func(r *GeoIPResponse) interface{} { return r.PostalCode },
+	"latitude":     func(r *GeoIPResponse) interface{} { return r.Latitude },
+	"longitude":    func(r *GeoIPResponse) interface{} { return r.Longitude },
+	"timezone":     func(r *GeoIPResponse) interface{} { return r.TimeZone },
+	"asn":          func(r *GeoIPResponse) interface{} { return r.ASN },
+	"asn_org":      func(r *GeoIPResponse) interface{} { return r.ASNOrg },
+	"asn_network":  func(r *GeoIPResponse) interface{} { return r.ASNNetwork },
+}
+
+// projectBulkResult narrows a successful result down to the requested
+// fields, leaving errors and unfiltered results untouched.
+func projectBulkResult(result BulkResult, fields []string) interface{} {
+	if len(fields) == 0 || result.GeoIPResponse == nil {
+		return result
+	}
+
+	out := map[string]interface{}{"ip": result.IP}
+	for _, f := range fields {
+		if setter, ok := bulkFieldSetters[strings.TrimSpace(f)]; ok {
+			out[strings.TrimSpace(f)] = setter(result.GeoIPResponse)
+		}
+	}
+	return out
+}
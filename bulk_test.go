@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseBulkIPsJSONArray(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	req := httptest.NewRequest(http.MethodPost, "/lookup/bulk", bytes.NewBufferString(`["1.1.1.1", "2.2.2.2"]`))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+
+	ips, err := parseBulkIPs(c)
+	if err != nil {
+		t.Fatalf("parseBulkIPs failed: %v", err)
+	}
+	if len(ips) != 2 || ips[0] != "1.1.1.1" || ips[1] != "2.2.2.2" {
+		t.Errorf("parseBulkIPs = %v, want [1.1.1.1 2.2.2.2]", ips)
+	}
+}
+
+func TestParseBulkIPsNewlineDelimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	req := httptest.NewRequest(http.MethodPost, "/lookup/bulk", bytes.NewBufferString("1.1.1.1\n\n2.2.2.2\n"))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+
+	ips, err := parseBulkIPs(c)
+	if err != nil {
+		t.Fatalf("parseBulkIPs failed: %v", err)
+	}
+	if len(ips) != 2 || ips[0] != "1.1.1.1" || ips[1] != "2.2.2.2" {
+		t.Errorf("parseBulkIPs = %v, want [1.1.1.1 2.2.2.2]", ips)
+	}
+}
+
+func TestBulkLookupOneInvalidIP(t *testing.T) {
+	g := &GeoIPService{provider: &fakeProvider{}}
+	result := g.bulkLookupOne("not-an-ip")
+	if result.Error == "" || result.GeoIPResponse != nil {
+		t.Errorf("expected an error result for an invalid IP, got %+v", result)
+	}
+}
+
+func TestProjectBulkResultFiltersFields(t *testing.T) {
+	result := BulkResult{IP: "1.1.1.1", GeoIPResponse: &GeoIPResponse{CountryCode: "US", City: "Springfield"}}
+
+	projected := projectBulkResult(result, []string{"country_code"})
+	out, ok := projected.(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected a projected map, got %T", projected)
+	}
+	if out["ip"] != "1.1.1.1" || out["country_code"] != "US" {
+		t.Errorf("projectBulkResult = %v, missing expected fields", out)
+	}
+	if _, present := out["city"]; present {
+		t.Errorf("projectBulkResult leaked unrequested field city: %v", out)
+	}
+}
+
+// closeNotifyRecorder adds the http.CloseNotifier that gin's Context.Stream
+// requires of the underlying writer; httptest.ResponseRecorder lacks it.
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan bool
+}
+
+func (r *closeNotifyRecorder) CloseNotify() <-chan bool { return r.closed }
+
+// TestBulkLookupAbortsOnClientDisconnect guards against the goroutine leak
+// where a disconnected client left the producer and every worker blocked
+// forever on a never-drained results channel.
+func TestBulkLookupAbortsOnClientDisconnect(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // simulate the client already having gone away
+
+	body := bytes.NewBufferString("1.1.1.1\n2.2.2.2\n3.3.3.3\n")
+	req := httptest.NewRequest(http.MethodPost, "/lookup/bulk", body).WithContext(ctx)
+
+	c, _ := gin.CreateTestContext(&closeNotifyRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool)})
+	c.Request = req
+
+	g := &GeoIPService{provider: &fakeProvider{}}
+
+	done := make(chan struct{})
+	go func() {
+		g.BulkLookup(c)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("BulkLookup did not return after the client context was canceled; producer/workers likely leaked")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"container/list"
+	"net"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultCacheSize is used when CACHE_SIZE is unset.
+const defaultCacheSize = 10000
+
+// cacheEntry is one node in the LRU's backing list.
+type cacheEntry struct {
+	key   string
+	value GeoIPResponse
+}
+
+// LookupCache is a bounded LRU cache of assembled GeoIPResponses keyed by
+// canonical IP string, with a singleflight group so a cold-cache burst on
+// the same IP only triggers one mmdb walk.
+type LookupCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+
+	group singleflight.Group
+}
+
+// NewLookupCache creates a cache holding up to capacity entries. A capacity
+// of 0 disables caching (NewLookupCache returns nil).
+func NewLookupCache(capacity int) *LookupCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &LookupCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element, capacity),
+	}
+}
+
+// Get returns the cached response for key, if present, marking it as most
+// recently used.
+func (c *LookupCache) Get(key string) (GeoIPResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return GeoIPResponse{}, false
+	}
+	c.ll.MoveToFront(elem)
+	cacheHitsTotal.Inc()
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// Set stores value under key, evicting the least-recently-used entry if the
+// cache is at capacity.
+func (c *LookupCache) Set(key string, value GeoIPResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).value = value
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	elem := c.ll.PushFront(&cacheEntry{key: key, value: value})
+	c.items[key] = elem
+
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*cacheEntry).key)
+		}
+	}
+
+	cacheSize.Set(float64(c.ll.Len()))
+}
+
+// Reset drops every cached entry; called after a database reload since
+// responses from the previous generation (build epoch, ASN data) are stale.
+func (c *LookupCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[string]*list.Element, c.capacity)
+	cacheSize.Set(0)
+}
+
+// lookupResponse assembles the full GeoIPResponse for ip, using the cache
+// (and its singleflight group to dedupe concurrent misses) when one is
+// configured. When wantHostname is set, a hostname that resolves is folded
+// into the cached entry, so later hostname-enabled lookups for the same IP
+// skip the DNS round trip too; an IP whose reverse lookup yields no name is
+// resolved again on each hostname-enabled lookup.
+func (g *GeoIPService) lookupResponse(ipStr string, ip net.IP, wantHostname bool) (GeoIPResponse, error) {
+	if g.cache == nil {
+		response, err := g.lookupResponseUncached(ipStr, ip)
+		if err != nil {
+			return GeoIPResponse{}, err
+		}
+		if wantHostname {
+			response.Hostname = g.resolveHostname(ip)
+		}
+		return response, nil
+	}
+
+	key := ip.String()
+	if cached, ok := g.cache.Get(key); ok {
+		// The cache is keyed by canonical IP form, but two requests that
+		// canonicalize identically (e.g. "2001:DB8::1" vs "2001:db8::1")
+		// can carry different literal text; always echo back what this
+		// caller actually queried with, not whatever was cached at fill time.
+		cached.IP = ipStr
+		return g.backfillHostname(cached, ip, wantHostname), nil
+	}
+	cacheMissesTotal.Inc()
+
+	v, err, _ := g.cache.group.Do(key, func() (interface{}, error) {
+		response, err := g.lookupResponseUncached(ipStr, ip)
+		if err != nil {
+			return GeoIPResponse{}, err
+		}
+		if wantHostname {
+			response.Hostname = g.resolveHostname(ip)
+		}
+		g.cache.Set(key, response)
+		return response, nil
+	})
+	if err != nil {
+		return GeoIPResponse{}, err
+	}
+	// Every follower on this singleflight call gets back the leader's
+	// response, but the leader's ipStr may not be what this caller queried
+	// with (same canonicalization mismatch as the cache-hit path above).
+	response := v.(GeoIPResponse)
+	response.IP = ipStr
+	return g.backfillHostname(response, ip, wantHostname), nil
+}
+
+// backfillHostname resolves and caches a hostname for an entry that was
+// cached before anyone asked for it, and strips the field back out for
+// callers who didn't request it so it never leaks to them.
+func (g *GeoIPService) backfillHostname(response GeoIPResponse, ip net.IP, wantHostname bool) GeoIPResponse {
+	if !wantHostname {
+		response.Hostname = ""
+		return response
+	}
+	if response.Hostname == "" {
+		response.Hostname = g.resolveHostname(ip)
+		if g.cache != nil {
+			g.cache.Set(ip.String(), response)
+		}
+	}
+	return response
+}
+
+// lookupResponseUncached asks the configured provider directly, with no
+// cache involved.
+func (g *GeoIPService) lookupResponseUncached(ipStr string, ip net.IP) (GeoIPResponse, error) {
+	response, err := g.provider.Lookup(ip)
+	if err != nil {
+		return GeoIPResponse{}, err
+	}
+	response.IP = ipStr
+	return *response, nil
+}
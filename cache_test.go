@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeProvider returns a fixed GeoIPResponse for every lookup, recording how
+// many times it was actually called so tests can assert on cache hits.
+type fakeProvider struct {
+	calls int
+	delay time.Duration
+}
+
+func (p *fakeProvider) Lookup(ip net.IP) (*GeoIPResponse, error) {
+	p.calls++
+	if p.delay > 0 {
+		time.Sleep(p.delay)
+	}
+	return &GeoIPResponse{CountryCode: "US"}, nil
+}
+
+func (p *fakeProvider) LookupASN(ip net.IP) (*ASNInfo, error) { return &ASNInfo{}, nil }
+func (p *fakeProvider) Close() error                          { return nil }
+
+func TestLookupResponseCacheHitEchoesQueriedIPText(t *testing.T) {
+	provider := &fakeProvider{}
+	g := &GeoIPService{provider: provider, cache: NewLookupCache(10)}
+
+	ip := net.ParseIP("2001:db8::1")
+
+	first, err := g.lookupResponse("2001:DB8::1", ip, false)
+	if err != nil {
+		t.Fatalf("lookupResponse failed: %v", err)
+	}
+	if first.IP != "2001:DB8::1" {
+		t.Errorf("first lookup IP = %q, want %q", first.IP, "2001:DB8::1")
+	}
+
+	second, err := g.lookupResponse("2001:db8::1", ip, false)
+	if err != nil {
+		t.Fatalf("lookupResponse failed: %v", err)
+	}
+	if second.IP != "2001:db8::1" {
+		t.Errorf("cache-hit IP = %q, want the literal text this caller queried with (%q)", second.IP, "2001:db8::1")
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected provider to be called once (second request should hit cache), got %d calls", provider.calls)
+	}
+}
+
+// TestLookupResponseSingleflightFollowerEchoesQueriedIPText covers the
+// cold-cache race: two requests for the same canonical IP in different
+// textual forms collapse onto one singleflight call, and the follower must
+// not get back the leader's literal query text.
+func TestLookupResponseSingleflightFollowerEchoesQueriedIPText(t *testing.T) {
+	provider := &fakeProvider{delay: 50 * time.Millisecond}
+	g := &GeoIPService{provider: provider, cache: NewLookupCache(10)}
+
+	ip := net.ParseIP("2001:db8::1")
+
+	type result struct {
+		response GeoIPResponse
+		err      error
+	}
+	leaderDone := make(chan result, 1)
+	followerDone := make(chan result, 1)
+
+	go func() {
+		response, err := g.lookupResponse("2001:DB8::1", ip, false)
+		leaderDone <- result{response, err}
+	}()
+	time.Sleep(10 * time.Millisecond) // let the leader enter group.Do first
+	go func() {
+		response, err := g.lookupResponse("2001:db8::1", ip, false)
+		followerDone <- result{response, err}
+	}()
+
+	leader := <-leaderDone
+	follower := <-followerDone
+
+	if leader.err != nil || follower.err != nil {
+		t.Fatalf("lookupResponse failed: leader=%v follower=%v", leader.err, follower.err)
+	}
+	if leader.response.IP != "2001:DB8::1" {
+		t.Errorf("leader IP = %q, want %q", leader.response.IP, "2001:DB8::1")
+	}
+	if follower.response.IP != "2001:db8::1" {
+		t.Errorf("follower IP = %q, want the literal text it queried with (%q), not the leader's", follower.response.IP, "2001:db8::1")
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected singleflight to collapse both calls into one provider lookup, got %d calls", provider.calls)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cloudflareIPv4URL and cloudflareIPv6URL are Cloudflare's published edge IP
+// ranges: https://www.cloudflare.com/ips/. CF_STRICT fetches and caches them
+// once at startup so the CF-Connecting-IP shortcut can require the
+// immediate peer to actually be Cloudflare, not just any TRUSTED_PROXIES
+// entry.
+const (
+	cloudflareIPv4URL = "https://www.cloudflare.com/ips-v4"
+	cloudflareIPv6URL = "https://www.cloudflare.com/ips-v6"
+)
+
+// cloudflareFetchTimeout bounds the whole startup fetch so a hung endpoint
+// can't stall server boot indefinitely.
+const cloudflareFetchTimeout = 10 * time.Second
+
+// fetchCloudflareRanges downloads and parses Cloudflare's published IPv4 and
+// IPv6 ranges. Called once at startup when CF_STRICT is enabled.
+func fetchCloudflareRanges() ([]*net.IPNet, error) {
+	client := &http.Client{Timeout: cloudflareFetchTimeout}
+
+	var ranges []*net.IPNet
+	for _, url := range []string{cloudflareIPv4URL, cloudflareIPv6URL} {
+		networks, err := fetchCIDRList(client, url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+		}
+		ranges = append(ranges, networks...)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no CIDR ranges found at %s or %s", cloudflareIPv4URL, cloudflareIPv6URL)
+	}
+	return ranges, nil
+}
+
+// fetchCIDRList retrieves url and parses its body as one CIDR per line,
+// which is the format of Cloudflare's ips-v4/ips-v6 endpoints.
+func fetchCIDRList(client *http.Client, url string) ([]*net.IPNet, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var networks []*net.IPNet
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", line, err)
+		}
+		networks = append(networks, network)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
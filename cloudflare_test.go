@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchCIDRListParsesOneEntryPerLine(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("173.245.48.0/20\n103.21.244.0/22\n\n141.101.64.0/18\n"))
+	}))
+	defer server.Close()
+
+	networks, err := fetchCIDRList(server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("fetchCIDRList failed: %v", err)
+	}
+	if len(networks) != 3 {
+		t.Fatalf("expected 3 networks, got %d", len(networks))
+	}
+	if !networks[0].Contains(net.ParseIP("173.245.48.1")) {
+		t.Errorf("expected first network to contain 173.245.48.1, got %v", networks[0])
+	}
+}
+
+func TestFetchCIDRListRejectsInvalidEntry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not-a-cidr\n"))
+	}))
+	defer server.Close()
+
+	if _, err := fetchCIDRList(server.Client(), server.URL); err == nil {
+		t.Fatal("expected an error for a malformed CIDR line, got nil")
+	}
+}
+
+func TestFetchCIDRListRejectsNon200Status(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := fetchCIDRList(server.Client(), server.URL); err == nil {
+		t.Fatal("expected an error for a non-200 status, got nil")
+	}
+}
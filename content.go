@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseFormat is the wire format chosen for a lookup response.
+type responseFormat string
+
+const (
+	formatJSON responseFormat = "json"
+	formatXML  responseFormat = "xml"
+	formatText responseFormat = "text"
+
+	// defaultGeohashPrecision matches the geohash length most clients expect
+	// (roughly neighborhood-level resolution).
+	defaultGeohashPrecision = 6
+)
+
+// pathFormatSuffixes maps a trailing path extension to the format it forces,
+// matching the echoip/ifconfig.co convention of e.g. GET /8.8.8.8.json.
+var pathFormatSuffixes = map[string]responseFormat{
+	".json": formatJSON,
+	".xml":  formatXML,
+	".txt":  formatText,
+}
+
+// splitFormatSuffix strips a trailing .json/.xml/.txt extension from raw, if
+// present, and reports the format it implies.
+func splitFormatSuffix(raw string) (string, responseFormat) {
+	for suffix, format := range pathFormatSuffixes {
+		if strings.HasSuffix(raw, suffix) {
+			return strings.TrimSuffix(raw, suffix), format
+		}
+	}
+	return raw, ""
+}
+
+// negotiateFormat picks the response format for a request: an explicit path
+// extension wins outright, otherwise it falls back to the Accept header,
+// with a bare "curl the endpoint" request landing on plain text.
+func negotiateFormat(c *gin.Context, pathFormat responseFormat) responseFormat {
+	if pathFormat != "" {
+		return pathFormat
+	}
+
+	switch c.NegotiateFormat(gin.MIMEPlain, gin.MIMEJSON, gin.MIMEXML) {
+	case gin.MIMEJSON:
+		return formatJSON
+	case gin.MIMEXML:
+		return formatXML
+	default:
+		return formatText
+	}
+}
+
+// geohashPrecision reads the `geohash_precision` query param, falling back
+// to defaultGeohashPrecision when absent or invalid.
+func geohashPrecision(c *gin.Context) int {
+	if raw := c.Query("geohash_precision"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 && n <= 12 {
+			return n
+		}
+	}
+	return defaultGeohashPrecision
+}
+
+// RespondGeoIP picks the response format for the request and writes response
+// in that format, so handlers don't each duplicate the negotiation branches.
+func RespondGeoIP(c *gin.Context, response GeoIPResponse, pathFormat responseFormat) {
+	if response.Latitude != 0 || response.Longitude != 0 {
+		response.Geohash = encodeGeohash(response.Latitude, response.Longitude, geohashPrecision(c))
+	}
+
+	format := negotiateFormat(c, pathFormat)
+	if wantUserAgent(c, format) {
+		response.UserAgent = parseUserAgent(c.GetHeader("User-Agent"))
+	}
+
+	switch format {
+	case formatXML:
+		c.XML(http.StatusOK, response)
+	case formatText:
+		renderText(c, response)
+	default:
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// renderText writes the text/plain representation: just the IP by default,
+// or a `key: value` block per line when `?verbose` is set.
+func renderText(c *gin.Context, response GeoIPResponse) {
+	if _, verbose := c.GetQuery("verbose"); !verbose {
+		c.String(http.StatusOK, response.IP)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "ip: %s\n", response.IP)
+	fmt.Fprintf(&b, "country: %s\n", response.Country)
+	fmt.Fprintf(&b, "country_code: %s\n", response.CountryCode)
+	fmt.Fprintf(&b, "region: %s\n", response.Region)
+	fmt.Fprintf(&b, "region_code: %s\n", response.RegionCode)
+	fmt.Fprintf(&b, "city: %s\n", response.City)
+	fmt.Fprintf(&b, "postal_code: %s\n", response.PostalCode)
+	fmt.Fprintf(&b, "timezone: %s\n", response.TimeZone)
+	fmt.Fprintf(&b, "geohash: %s\n", response.Geohash)
+	if response.CountryIsEU != nil {
+		fmt.Fprintf(&b, "country_is_eu: %t\n", *response.CountryIsEU)
+	}
+	if response.Hostname != "" {
+		fmt.Fprintf(&b, "hostname: %s\n", response.Hostname)
+	}
+	if response.UserAgent != nil {
+		fmt.Fprintf(&b, "user_agent: %s/%s (%s %s)\n", response.UserAgent.Product, response.UserAgent.Version, response.UserAgent.OS, response.UserAgent.OSVersion)
+	}
+	fmt.Fprintf(&b, "asn: %d\n", response.ASN)
+	fmt.Fprintf(&b, "asn_org: %s\n", response.ASNOrg)
+	c.String(http.StatusOK, b.String())
+}
+
+// geohashBase32 is the base32 alphabet used by the geohash algorithm (note:
+// not standard base32 - it omits "a", "i", "l", "o" to avoid ambiguity).
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// encodeGeohash encodes a lat/lon pair into a geohash string of the given
+// precision, interleaving bits from a binary search over each axis' range.
+func encodeGeohash(lat, lon float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+
+	var b strings.Builder
+	bit, ch, evenBit := 0, 0, true
+
+	for b.Len() < precision {
+		if evenBit {
+			mid := (lonRange[0] + lonRange[1]) / 2
+			if lon >= mid {
+				ch |= 1 << (4 - bit)
+				lonRange[0] = mid
+			} else {
+				lonRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if lat >= mid {
+				ch |= 1 << (4 - bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+		evenBit = !evenBit
+
+		if bit < 4 {
+			bit++
+		} else {
+			b.WriteByte(geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+
+	return b.String()
+}
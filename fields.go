@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fieldProjectors maps a CLI-ergonomics route to the function that extracts
+// that single field's plain-text value from an assembled GeoIPResponse,
+// mirroring `curl ifconfig.co/city`-style tools.
+var fieldProjectors = map[string]func(GeoIPResponse) string{
+	"/ip":          func(r GeoIPResponse) string { return r.IP },
+	"/country":     func(r GeoIPResponse) string { return r.Country },
+	"/country-iso": func(r GeoIPResponse) string { return r.CountryCode },
+	"/city":        func(r GeoIPResponse) string { return r.City },
+	"/asn":         func(r GeoIPResponse) string { return fmt.Sprintf("%d", r.ASN) },
+	"/asn-org":     func(r GeoIPResponse) string { return r.ASNOrg },
+}
+
+// FieldLookup handles the single-field CLI routes (/ip, /country, /city,
+// ...): it assembles the full response for the caller's IP once via the
+// shared cached lookup path, then writes back just the one field as
+// text/plain.
+func (g *GeoIPService) FieldLookup(c *gin.Context) {
+	project, ok := fieldProjectors[c.FullPath()]
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	clientIP := getClientIP(c, g.trustedProxies, g.ipHeaders, g.trustCFConnectingIP, g.cfStrict, g.cfRanges)
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to determine client IP"})
+		return
+	}
+
+	response, err := g.lookupResponse(clientIP, ip, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lookup client IP address"})
+		return
+	}
+
+	c.String(http.StatusOK, project(response))
+}
@@ -2,71 +2,30 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/oschwald/geoip2-golang"
-	"github.com/oschwald/maxminddb-golang"
 )
 
-// NewGeoIPService creates a new GeoIP service instance
-func NewGeoIPService(dataDir string) (*GeoIPService, error) {
-	// Open City database
-	cityDB, err := geoip2.Open(dataDir + "/GeoLite2-City.mmdb")
-	if err != nil {
-		return nil, fmt.Errorf("failed to open City database: %w", err)
-	}
-
-	// Open ASN database
-	asnDB, err := geoip2.Open(dataDir + "/GeoLite2-ASN.mmdb")
-	if err != nil {
-		cityDB.Close() // Clean up city DB if ASN fails
-		return nil, fmt.Errorf("failed to open ASN database: %w", err)
-	}
-
-	// Open ASN database with maxminddb for network information
-	asnRawDB, err := maxminddb.Open(dataDir + "/GeoLite2-ASN.mmdb")
-	if err != nil {
-		cityDB.Close()
-		asnDB.Close()
-		return nil, fmt.Errorf("failed to open ASN raw database: %w", err)
-	}
-
-	return &GeoIPService{
-		cityDB:   cityDB,
-		asnDB:    asnDB,
-		asnRawDB: asnRawDB,
-	}, nil
+// NewGeoIPService wraps provider with the cross-cutting lookup behavior
+// (caching, hostname resolution, rule-based decisions) shared by every
+// backend.
+func NewGeoIPService(provider GeoProvider) *GeoIPService {
+	return &GeoIPService{provider: provider}
 }
 
-// Close closes the GeoIP databases
+// Close shuts down the configured provider.
 func (g *GeoIPService) Close() error {
-	var err1, err2, err3 error
-	if g.cityDB != nil {
-		err1 = g.cityDB.Close()
-	}
-	if g.asnDB != nil {
-		err2 = g.asnDB.Close()
-	}
-	if g.asnRawDB != nil {
-		err3 = g.asnRawDB.Close()
-	}
-
-	if err1 != nil {
-		return err1
-	}
-	if err2 != nil {
-		return err2
-	}
-	return err3
+	return g.provider.Close()
 }
 
-// LookupIP handles IP lookup requests
+// LookupIP handles IP lookup requests. The format is negotiated from a
+// trailing .json/.xml/.txt extension on the path, or the Accept header.
 func (g *GeoIPService) LookupIP(c *gin.Context) {
-	ipStr := c.Param("ip")
+	ipStr, pathFormat := splitFormatSuffix(c.Param("ip"))
 	if ipStr == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "IP address is required"})
 		return
@@ -78,49 +37,32 @@ func (g *GeoIPService) LookupIP(c *gin.Context) {
 		return
 	}
 
-	cityRecord, err := g.cityDB.City(ip)
+	response, err := g.lookupResponse(ipStr, ip, g.wantHostname(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lookup IP address"})
 		return
 	}
 
-	// Create base response using helper function
-	response := NewGeoIPResponse(ipStr, cityRecord)
-
-	// Add ASN information using helper function
-	AddASNInformation(&response, ip, ipStr, g.asnDB, g.asnRawDB)
-
-	c.JSON(http.StatusOK, response)
+	RespondGeoIP(c, response, pathFormat)
 }
 
-// GetClientIP returns information about the client's IP address
+// GetClientIP returns information about the client's IP address. The format
+// is negotiated from the Accept header, same as LookupIP.
 func (g *GeoIPService) GetClientIP(c *gin.Context) {
-	// Log all headers for debugging
-	log.Println("=== Client IP Debug Info ===")
-	log.Printf("RemoteAddr: %s", c.Request.RemoteAddr)
-	log.Printf("CF-Connecting-IP: %s", c.GetHeader("CF-Connecting-IP"))
-	log.Printf("True-Client-IP: %s", c.GetHeader("True-Client-IP"))
-	log.Printf("X-Real-IP: %s", c.GetHeader("X-Real-IP"))
-	log.Printf("X-Forwarded-For: %s", c.GetHeader("X-Forwarded-For"))
-	log.Printf("X-Forwarded: %s", c.GetHeader("X-Forwarded"))
-	log.Printf("Forwarded: %s", c.GetHeader("Forwarded"))
-	log.Printf("X-Client-IP: %s", c.GetHeader("X-Client-IP"))
-	log.Printf("X-Cluster-Client-IP: %s", c.GetHeader("X-Cluster-Client-IP"))
-	log.Printf("X-Original-Forwarded-For: %s", c.GetHeader("X-Original-Forwarded-For"))
-	log.Printf("CF-IPCountry: %s", c.GetHeader("CF-IPCountry"))
-	log.Printf("Gin ClientIP(): %s", c.ClientIP())
-	
-	// Log all headers for complete debugging
-	log.Println("All headers:")
-	for name, values := range c.Request.Header {
-		for _, value := range values {
-			log.Printf("  %s: %s", name, value)
-		}
-	}
-
-	clientIP := getClientIP(c)
-	log.Printf("Final extracted client IP: %s", clientIP)
-	log.Println("=== End Debug Info ===")
+	slog.Debug("resolving client IP",
+		"remote_addr", c.Request.RemoteAddr,
+		"cf_connecting_ip", c.GetHeader("CF-Connecting-IP"),
+		"true_client_ip", c.GetHeader("True-Client-IP"),
+		"x_real_ip", c.GetHeader("X-Real-IP"),
+		"x_forwarded_for", c.GetHeader("X-Forwarded-For"),
+		"x_forwarded", c.GetHeader("X-Forwarded"),
+		"forwarded", c.GetHeader("Forwarded"),
+		"cf_ip_country", c.GetHeader("CF-IPCountry"),
+		"gin_client_ip", c.ClientIP(),
+	)
+
+	clientIP := getClientIP(c, g.trustedProxies, g.ipHeaders, g.trustCFConnectingIP, g.cfStrict, g.cfRanges)
+	slog.Debug("resolved client IP", "client_ip", clientIP)
 
 	ip := net.ParseIP(clientIP)
 	if ip == nil {
@@ -128,144 +70,187 @@ func (g *GeoIPService) GetClientIP(c *gin.Context) {
 			"error": "Unable to determine client IP",
 			"debug": gin.H{
 				"extracted_ip": clientIP,
-				"remote_addr": c.Request.RemoteAddr,
+				"remote_addr":  c.Request.RemoteAddr,
 				"headers": gin.H{
 					"cf_connecting_ip": c.GetHeader("CF-Connecting-IP"),
-					"x_real_ip": c.GetHeader("X-Real-IP"),
-					"x_forwarded_for": c.GetHeader("X-Forwarded-For"),
+					"x_real_ip":        c.GetHeader("X-Real-IP"),
+					"x_forwarded_for":  c.GetHeader("X-Forwarded-For"),
 				},
 			},
 		})
 		return
 	}
 
-	cityRecord, err := g.cityDB.City(ip)
+	response, err := g.lookupResponse(clientIP, ip, g.wantHostname(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lookup client IP address"})
 		return
 	}
 
-	// Create base response using helper function
-	response := NewGeoIPResponse(clientIP, cityRecord)
-
-	// Add ASN information using helper function
-	AddASNInformation(&response, ip, clientIP, g.asnDB, g.asnRawDB)
-
-	// Add debug information to response
-	response.Debug = gin.H{
-		"remote_addr": c.Request.RemoteAddr,
-		"headers": gin.H{
-			"cf_connecting_ip": c.GetHeader("CF-Connecting-IP"),
-			"x_real_ip": c.GetHeader("X-Real-IP"),
-			"x_forwarded_for": c.GetHeader("X-Forwarded-For"),
-			"gin_client_ip": c.ClientIP(),
-		},
-	}
+	RespondGeoIP(c, response, "")
+}
 
-	c.JSON(http.StatusOK, response)
+// defaultIPHeaders is the header priority chain getClientIP checks when no
+// IP_HEADERS override is configured, matching this server's historical,
+// hardcoded order.
+var defaultIPHeaders = []string{
+	"CF-Connecting-IP",
+	"True-Client-IP",
+	"X-Real-IP",
+	"X-Forwarded-For",
+	"X-Client-IP",
+	"X-Cluster-Client-IP",
+	"X-Original-Forwarded-For",
+	"X-Forwarded",
+	"Forwarded",
 }
 
-// getClientIP extracts the client IP address from the request
-func getClientIP(c *gin.Context) string {
-	// Check Cloudflare headers first - CF-Connecting-IP contains the original client IP
-	cfConnectingIP := c.GetHeader("CF-Connecting-IP")
-	if cfConnectingIP != "" && isValidPublicIP(cfConnectingIP) {
-		log.Printf("Found valid CF-Connecting-IP: %s", cfConnectingIP)
-		return cfConnectingIP
+// ipHeaderMetricLabel maps a header name to its clientIPSourceTotal label,
+// preserving the label values this metric has always used; headers with no
+// specific label (including any custom ones from IP_HEADERS) fall under "other".
+func ipHeaderMetricLabel(header string) string {
+	switch header {
+	case "CF-Connecting-IP":
+		return "cf"
+	case "X-Real-IP":
+		return "xrealip"
+	case "X-Forwarded-For":
+		return "xff"
+	default:
+		return "other"
 	}
+}
 
-	// Check CF-IPCountry header to verify we're behind Cloudflare
-	cfIPCountry := c.GetHeader("CF-IPCountry")
-	if cfIPCountry != "" {
-		log.Printf("Behind Cloudflare (CF-IPCountry: %s) but CF-Connecting-IP not found or invalid: %s", cfIPCountry, cfConnectingIP)
+// getClientIP extracts the client IP address from the request by checking
+// headers, in order, from ipHeaders (or defaultIPHeaders when empty, as set
+// by IP_HEADERS). X-Forwarded-For and Forwarded keep their chain-aware
+// parsing no matter where they fall in the list; every other header is
+// taken at face value when it holds a valid public IP. When trustedProxies
+// is non-empty, X-Forwarded-For is parsed by walking the chain from the
+// nearest hop backwards and stopping at the first untrusted address,
+// instead of naively trusting whichever entry looks public. CF-Connecting-IP
+// is skipped entirely when cfEnabled is false (TRUST_CF_CONNECTING_IP=false),
+// and additionally requires RemoteAddr to fall within cfRanges (Cloudflare's
+// published ranges, see fetchCloudflareRanges) when cfStrict is set
+// (CF_STRICT=true), since the header otherwise carries no chain-of-custody
+// check at all.
+func getClientIP(c *gin.Context, trustedProxies []*net.IPNet, ipHeaders []string, cfEnabled, cfStrict bool, cfRanges []*net.IPNet) string {
+	if len(ipHeaders) == 0 {
+		ipHeaders = defaultIPHeaders
+	}
+
+	// CF-IPCountry is diagnostic only: its presence without a usable
+	// CF-Connecting-IP is worth a debug line regardless of header order.
+	if cfIPCountry := c.GetHeader("CF-IPCountry"); cfIPCountry != "" {
+		slog.Debug("behind Cloudflare", "cf_ip_country", cfIPCountry, "cf_connecting_ip", c.GetHeader("CF-Connecting-IP"))
+	}
+
+	for _, header := range ipHeaders {
+		switch header {
+		case "X-Forwarded-For":
+			if ip := resolveXFFHeader(c, trustedProxies); ip != "" {
+				clientIPSourceTotal.WithLabelValues("xff").Inc()
+				return ip
+			}
+		case "Forwarded":
+			if ip := resolveForwardedHeader(c); ip != "" {
+				slog.Debug("client IP resolved from Forwarded header", "ip", ip)
+				clientIPSourceTotal.WithLabelValues("other").Inc()
+				return ip
+			}
+		case "CF-Connecting-IP":
+			if !cfEnabled {
+				continue
+			}
+			if cfStrict && !isTrustedProxy(net.ParseIP(remoteIP(c.Request.RemoteAddr)), cfRanges) {
+				continue
+			}
+			if value := c.GetHeader(header); value != "" && isValidPublicIP(value) {
+				slog.Debug("client IP resolved from header", "header", header, "ip", value)
+				clientIPSourceTotal.WithLabelValues(ipHeaderMetricLabel(header)).Inc()
+				return value
+			}
+		default:
+			if value := c.GetHeader(header); value != "" && isValidPublicIP(value) {
+				slog.Debug("client IP resolved from header", "header", header, "ip", value)
+				clientIPSourceTotal.WithLabelValues(ipHeaderMetricLabel(header)).Inc()
+				return value
+			}
+		}
 	}
 
-	// Check True-Client-IP (used by some CDNs and load balancers)
-	trueClientIP := c.GetHeader("True-Client-IP")
-	if trueClientIP != "" && isValidPublicIP(trueClientIP) {
-		log.Printf("Found valid True-Client-IP: %s", trueClientIP)
-		return trueClientIP
-	}
+	// Fall back to RemoteAddr (this will likely be the pod IP in Kubernetes)
+	remoteAddr := c.ClientIP()
+	clientIPSourceTotal.WithLabelValues("remote").Inc()
 
-	// Check X-Real-IP header (commonly used by nginx and other proxies)
-	xRealIP := c.GetHeader("X-Real-IP")
-	if xRealIP != "" && isValidPublicIP(xRealIP) {
-		log.Printf("Found valid X-Real-IP: %s", xRealIP)
-		return xRealIP
+	// If RemoteAddr is a private IP (like 172.18.0.1), it means no proxy headers were set
+	if isPrivateIP(remoteAddr) {
+		slog.Warn("falling back to private RemoteAddr, no valid proxy headers found", "ip", remoteAddr)
+	} else {
+		slog.Debug("client IP resolved from RemoteAddr", "ip", remoteAddr)
 	}
 
-	// Check X-Forwarded-For header - this should contain the original client IP
+	return remoteAddr
+}
+
+// resolveXFFHeader returns the client IP from X-Forwarded-For, walking the
+// chain from the nearest hop backwards and stopping at the first untrusted
+// address when trustedProxies is configured, or falling back to the old
+// best-effort (spoofable) heuristic of picking the first public-looking
+// entry otherwise. Returns "" if the header is absent or yields nothing usable.
+func resolveXFFHeader(c *gin.Context, trustedProxies []*net.IPNet) string {
 	xForwardedFor := c.GetHeader("X-Forwarded-For")
-	if xForwardedFor != "" {
-		log.Printf("Processing X-Forwarded-For: %s", xForwardedFor)
-		// X-Forwarded-For can contain multiple IPs, check each one
-		ips := strings.Split(xForwardedFor, ",")
-		for i, ip := range ips {
-			cleanIP := strings.TrimSpace(ip)
-			log.Printf("  IP %d: %s (valid: %t, public: %t)", i, cleanIP, isValidIP(cleanIP), isValidPublicIP(cleanIP))
-			// Return the first valid public IP
-			if isValidPublicIP(cleanIP) {
-				log.Printf("Selected public IP from X-Forwarded-For: %s", cleanIP)
-				return cleanIP
-			}
-		}
-		// If no public IP found, return the first valid IP anyway (might be internal but still useful)
-		if len(ips) > 0 {
-			firstIP := strings.TrimSpace(ips[0])
-			if isValidIP(firstIP) {
-				log.Printf("No public IP found, using first IP from X-Forwarded-For: %s", firstIP)
-				return firstIP
-			}
-		}
+	if xForwardedFor == "" {
+		return ""
 	}
 
-	// Check additional headers commonly used by various proxies and load balancers
-	headers := []string{
-		"X-Client-IP",
-		"X-Cluster-Client-IP", 
-		"X-Original-Forwarded-For",
-		"X-Forwarded",
+	if len(trustedProxies) > 0 {
+		clientIP := resolveTrustedXFF(remoteIP(c.Request.RemoteAddr), xForwardedFor, trustedProxies)
+		slog.Debug("client IP resolved from trusted X-Forwarded-For chain", "ip", clientIP)
+		return clientIP
 	}
-	
-	for _, header := range headers {
-		value := c.GetHeader(header)
-		if value != "" && isValidPublicIP(value) {
-			log.Printf("Found valid %s: %s", header, value)
-			return value
+
+	// No trusted proxies configured: fall back to the old best-effort
+	// heuristic of picking the first public-looking IP in the chain.
+	// This is spoofable and only exists for unconfigured deployments.
+	ips := strings.Split(xForwardedFor, ",")
+	for _, ip := range ips {
+		cleanIP := strings.TrimSpace(ip)
+		if isValidPublicIP(cleanIP) {
+			slog.Debug("client IP resolved from X-Forwarded-For (untrusted heuristic)", "ip", cleanIP)
+			return cleanIP
 		}
 	}
-
-	// Check Forwarded header (RFC 7239)
-	forwarded := c.GetHeader("Forwarded")
-	if forwarded != "" {
-		log.Printf("Processing Forwarded header: %s", forwarded)
-		// Parse the Forwarded header for the "for" field
-		parts := strings.Split(forwarded, ";")
-		for _, part := range parts {
-			if strings.HasPrefix(strings.TrimSpace(part), "for=") {
-				forValue := strings.TrimPrefix(strings.TrimSpace(part), "for=")
-				// Remove quotes if present
-				forValue = strings.Trim(forValue, "\"")
-				// Handle IPv6 brackets
-				forValue = strings.Trim(forValue, "[]")
-				if isValidPublicIP(forValue) {
-					log.Printf("Found valid IP in Forwarded header: %s", forValue)
-					return forValue
-				}
-			}
+	// If no public IP found, return the first valid IP anyway (might be internal but still useful)
+	if len(ips) > 0 {
+		firstIP := strings.TrimSpace(ips[0])
+		if isValidIP(firstIP) {
+			slog.Debug("no public IP in X-Forwarded-For, using first entry", "ip", firstIP)
+			return firstIP
 		}
 	}
+	return ""
+}
 
-	// Fall back to RemoteAddr (this will likely be the pod IP in Kubernetes)
-	remoteAddr := c.ClientIP()
-	log.Printf("Falling back to RemoteAddr: %s", remoteAddr)
-	
-	// If RemoteAddr is a private IP (like 172.18.0.1), it means no proxy headers were set
-	if isPrivateIP(remoteAddr) {
-		log.Printf("WARNING: Returning private IP %s - no valid proxy headers found. Check proxy configuration.", remoteAddr)
+// resolveForwardedHeader extracts the "for=" field from an RFC 7239
+// Forwarded header, returning "" if absent or not a valid public IP.
+func resolveForwardedHeader(c *gin.Context) string {
+	forwarded := c.GetHeader("Forwarded")
+	if forwarded == "" {
+		return ""
 	}
-	
-	return remoteAddr
+	for _, part := range strings.Split(forwarded, ";") {
+		if !strings.HasPrefix(strings.TrimSpace(part), "for=") {
+			continue
+		}
+		forValue := strings.TrimPrefix(strings.TrimSpace(part), "for=")
+		forValue = strings.Trim(forValue, "\"") // remove quotes if present
+		forValue = strings.Trim(forValue, "[]") // handle IPv6 brackets
+		if isValidPublicIP(forValue) {
+			return forValue
+		}
+	}
+	return ""
 }
 
 // isPrivateIP checks if an IP address is private/internal
@@ -314,7 +299,7 @@ func isValidPublicIP(ipStr string) bool {
 // This endpoint is specifically designed for Traefik ForwardAuth middleware
 func (g *GeoIPService) ForwardAuthLookup(c *gin.Context) {
 	// Get the client IP from headers set by Traefik
-	clientIP := getClientIP(c)
+	clientIP := getClientIP(c, g.trustedProxies, g.ipHeaders, g.trustCFConnectingIP, g.cfStrict, g.cfRanges)
 
 	ip := net.ParseIP(clientIP)
 	if ip == nil {
@@ -323,18 +308,45 @@ func (g *GeoIPService) ForwardAuthLookup(c *gin.Context) {
 		return
 	}
 
-	cityRecord, err := g.cityDB.City(ip)
+	response, err := g.lookupResponse(clientIP, ip, false)
 	if err != nil {
-		// Return 200 but without geo headers if lookup fails
-		c.Status(http.StatusOK)
+		if g.rules == nil {
+			// Return 200 but without geo headers if lookup fails
+			c.Status(http.StatusOK)
+			return
+		}
+		// A lookup failure must not bypass deny rules: evaluate against the
+		// zero-value ASN/country so a catch-all deny/unauthorized rule still
+		// fires, and fail closed otherwise rather than forwarding to origin.
+		decision := g.rules.Evaluate(ip, 0, "", "")
+		switch {
+		case decision.Matched && decision.Action == RuleActionDeny:
+			c.Status(http.StatusForbidden)
+		case decision.Matched && decision.Action == RuleActionUnauthorized:
+			c.Status(http.StatusUnauthorized)
+		default:
+			c.Status(http.StatusBadGateway)
+		}
 		return
 	}
 
-	// Create base response using helper function to get all the data
-	response := NewGeoIPResponse(clientIP, cityRecord)
-
-	// Add ASN information using helper function
-	AddASNInformation(&response, ip, clientIP, g.asnDB, g.asnRawDB)
+	// Consult the rule engine, if configured, before forwarding geo headers
+	if g.rules != nil {
+		decision := g.rules.Evaluate(ip, response.ASN, response.ASNOrg, response.CountryCode)
+		if decision.Matched {
+			if decision.Tag != "" {
+				c.Header("X-GeoIP-Tag", decision.Tag)
+			}
+			switch decision.Action {
+			case RuleActionDeny:
+				c.Status(http.StatusForbidden)
+				return
+			case RuleActionUnauthorized:
+				c.Status(http.StatusUnauthorized)
+				return
+			}
+		}
+	}
 
 	// Set all geographic and ASN information as headers for ForwardAuth
 	c.Header("X-GeoIP-IP", response.IP)
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultHostnameTimeout bounds a reverse DNS lookup when the server wasn't
+// given an explicit HOSTNAME_TIMEOUT.
+const defaultHostnameTimeout = 500 * time.Millisecond
+
+// wantHostname reports whether reverse DNS resolution should be attempted
+// for this request: either the caller asked for it via ?hostname=true, or
+// the server was started with hostname lookups on by default.
+func (g *GeoIPService) wantHostname(c *gin.Context) bool {
+	if raw := c.Query("hostname"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		return err == nil && enabled
+	}
+	return g.hostnameDefault
+}
+
+// resolveHostname performs a best-effort reverse DNS lookup for ip, bounded
+// by g.hostnameTimeout so a slow or unresponsive resolver can't stall the
+// response. An empty string is returned on timeout, error, or no records.
+func (g *GeoIPService) resolveHostname(ip net.IP) string {
+	timeout := g.hostnameTimeout
+	if timeout <= 0 {
+		timeout = defaultHostnameTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ipstackBaseURL is the IPStack API endpoint. Overridden in tests.
+var ipstackBaseURL = "https://api.ipstack.com"
+
+// ipstackTimeout bounds a single API call so a slow remote never stalls a
+// request indefinitely.
+const ipstackTimeout = 5 * time.Second
+
+// IPStackProvider is the GeoProvider backed by the IPStack HTTP API, for
+// operators without a MaxMind license.
+type IPStackProvider struct {
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewIPStackProvider returns a provider that calls the IPStack API with the
+// given access key.
+func NewIPStackProvider(apiKey string) *IPStackProvider {
+	return &IPStackProvider{
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: ipstackTimeout},
+	}
+}
+
+// ipstackResponse is the subset of the IPStack JSON payload this provider
+// uses; see https://ipstack.com/documentation.
+type ipstackResponse struct {
+	IP          string  `json:"ip"`
+	CountryName string  `json:"country_name"`
+	CountryCode string  `json:"country_code"`
+	RegionName  string  `json:"region_name"`
+	RegionCode  string  `json:"region_code"`
+	City        string  `json:"city"`
+	Zip         string  `json:"zip"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	TimeZone    struct {
+		ID string `json:"id"`
+	} `json:"time_zone"`
+	Connection struct {
+		ASN uint   `json:"asn"`
+		ISP string `json:"isp"`
+	} `json:"connection"`
+	Success bool `json:"success"`
+	Error   struct {
+		Info string `json:"info"`
+	} `json:"error"`
+}
+
+// fetch calls the IPStack API for ip and decodes the response.
+func (p *IPStackProvider) fetch(ip net.IP) (*ipstackResponse, error) {
+	url := fmt.Sprintf("%s/%s?access_key=%s&format=1", ipstackBaseURL, ip.String(), p.apiKey)
+	resp, err := p.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("ipstack request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body ipstackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("ipstack response decode failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ipstack request returned %s: %s", resp.Status, body.Error.Info)
+	}
+	// IPStack reports real failures (bad/expired key, rate limit, ...) as
+	// HTTP 200 with an error payload instead of a non-2xx status; the
+	// "success" field is only ever present on those error responses (a
+	// normal lookup omits it, so it zero-values to false), so Error.Info is
+	// the reliable signal that this wasn't an actual result.
+	if body.Error.Info != "" {
+		return nil, fmt.Errorf("ipstack request failed: %s", body.Error.Info)
+	}
+	return &body, nil
+}
+
+// Lookup implements GeoProvider.
+func (p *IPStackProvider) Lookup(ip net.IP) (*GeoIPResponse, error) {
+	body, err := p.fetch(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &GeoIPResponse{
+		IP:          ip.String(),
+		Country:     body.CountryName,
+		CountryCode: body.CountryCode,
+		Region:      body.RegionName,
+		RegionCode:  body.RegionCode,
+		City:        body.City,
+		PostalCode:  body.Zip,
+		Latitude:    body.Latitude,
+		Longitude:   body.Longitude,
+		TimeZone:    body.TimeZone.ID,
+		ASN:         body.Connection.ASN,
+		ASNOrg:      body.Connection.ISP,
+	}
+	return response, nil
+}
+
+// LookupASN implements GeoProvider.
+func (p *IPStackProvider) LookupASN(ip net.IP) (*ASNInfo, error) {
+	body, err := p.fetch(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &ASNInfo{ASN: body.Connection.ASN, Org: body.Connection.ISP}, nil
+}
+
+// Close implements GeoProvider. IPStackProvider holds no resources besides
+// an *http.Client, which needs no explicit shutdown.
+func (p *IPStackProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func withIPStackServer(t *testing.T, body string, status int) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	original := ipstackBaseURL
+	ipstackBaseURL = server.URL
+	t.Cleanup(func() { ipstackBaseURL = original })
+}
+
+func TestIPStackProviderLookupSuccess(t *testing.T) {
+	withIPStackServer(t, `{"ip":"1.1.1.1","country_name":"Australia","country_code":"AU","connection":{"asn":13335,"isp":"Cloudflare"}}`, http.StatusOK)
+
+	p := NewIPStackProvider("testkey")
+	response, err := p.Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if response.CountryCode != "AU" || response.ASN != 13335 {
+		t.Errorf("Lookup = %+v, want country_code=AU asn=13335", response)
+	}
+}
+
+func TestIPStackProviderLookupErrorPayloadWithHTTP200(t *testing.T) {
+	withIPStackServer(t, `{"success":false,"error":{"code":104,"type":"usage_limit_reached","info":"Your monthly usage limit has been reached"}}`, http.StatusOK)
+
+	p := NewIPStackProvider("testkey")
+	_, err := p.Lookup(net.ParseIP("1.1.1.1"))
+	if err == nil {
+		t.Fatal("expected an error for an IPStack error payload shipped with HTTP 200, got nil")
+	}
+}
+
+func TestIPStackProviderLookupNon200Status(t *testing.T) {
+	withIPStackServer(t, `{"error":{"info":"invalid access key"}}`, http.StatusUnauthorized)
+
+	p := NewIPStackProvider("testkey")
+	_, err := p.Lookup(net.ParseIP("1.1.1.1"))
+	if err == nil {
+		t.Fatal("expected an error for a non-200 IPStack response, got nil")
+	}
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -13,19 +15,117 @@ import (
 )
 
 func main() {
+	// Debug-level request/client-IP diagnostics go through slog as structured
+	// JSON; level is controlled by LOG_LEVEL (debug, info, warn, error)
+	logLevel := slog.LevelInfo
+	if lvl := os.Getenv("LOG_LEVEL"); lvl != "" {
+		_ = logLevel.UnmarshalText([]byte(lvl))
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})))
+
 	// Get data directory from environment or use default
 	dataDir := os.Getenv("DATA_DIR")
 	if dataDir == "" {
 		dataDir = "./data"
 	}
 
-	// Initialize the GeoIP service with data directory
-	geoIPService, err := NewGeoIPService(dataDir)
+	// PROVIDER selects the GeoProvider backend: "maxmind" (default, needs a
+	// GeoLite2 license in DATA_DIR), "ipstack" (needs IPSTACK_API_KEY), or
+	// "maxmind+ipstack" to fall back to IPStack on a local miss.
+	provider, err := NewGeoProvider(ProviderConfig{
+		Name:    os.Getenv("PROVIDER"),
+		DataDir: dataDir,
+		APIKey:  os.Getenv("IPSTACK_API_KEY"),
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize GeoIP service: %v", err)
+		log.Fatalf("Failed to initialize GeoIP provider: %v", err)
 	}
+
+	// Initialize the GeoIP service on top of the configured provider
+	geoIPService := NewGeoIPService(provider)
 	defer geoIPService.Close()
 
+	// TRUST_CF_CONNECTING_IP toggles the CF-Connecting-IP shortcut in
+	// getClientIP; on by default to preserve historical behavior. CF_STRICT
+	// additionally requires RemoteAddr to fall within Cloudflare's published
+	// ranges before the header is trusted, for deployments that aren't
+	// otherwise network-isolated behind Cloudflare.
+	geoIPService.trustCFConnectingIP = true
+	if raw := os.Getenv("TRUST_CF_CONNECTING_IP"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			log.Fatalf("Invalid TRUST_CF_CONNECTING_IP: %v", err)
+		}
+		geoIPService.trustCFConnectingIP = enabled
+	}
+	if raw := os.Getenv("CF_STRICT"); raw != "" {
+		strict, err := strconv.ParseBool(raw)
+		if err != nil {
+			log.Fatalf("Invalid CF_STRICT: %v", err)
+		}
+		geoIPService.cfStrict = strict
+		if strict {
+			ranges, err := fetchCloudflareRanges()
+			if err != nil {
+				log.Fatalf("CF_STRICT requires Cloudflare's published IP ranges, fetch failed: %v", err)
+			}
+			geoIPService.cfRanges = ranges
+		}
+	}
+
+	// Trusted proxy CIDRs gate how much of X-Forwarded-For we trust
+	if raw := os.Getenv("TRUSTED_PROXIES"); raw != "" {
+		trustedProxies, err := parseTrustedProxies(raw)
+		if err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+		geoIPService.trustedProxies = trustedProxies
+	}
+
+	// IP_HEADERS overrides the default header priority chain getClientIP
+	// checks, since different reverse proxies set the client IP on different
+	// headers (e.g. "CF-Connecting-IP,X-Forwarded-For").
+	if raw := os.Getenv("IP_HEADERS"); raw != "" {
+		geoIPService.ipHeaders = parseIPHeaders(raw)
+	}
+
+	// Rule file is optional: without it, ForwardAuth/decide just pass through
+	if rulesFile := os.Getenv("RULES_FILE"); rulesFile != "" {
+		rules, err := NewRuleEngine(rulesFile)
+		if err != nil {
+			log.Fatalf("Failed to load rule file: %v", err)
+		}
+		geoIPService.rules = rules
+	}
+
+	// Lookup cache capacity; CACHE_SIZE=0 disables caching entirely
+	cacheCapacity := defaultCacheSize
+	if raw := os.Getenv("CACHE_SIZE"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil {
+			log.Fatalf("Invalid CACHE_SIZE: %v", err)
+		}
+		cacheCapacity = size
+	}
+	geoIPService.cache = NewLookupCache(cacheCapacity)
+
+	// Reverse DNS is off by default (see chunk1-2): opt in globally with
+	// HOSTNAME_LOOKUP=true, or per-request with ?hostname=true.
+	if raw := os.Getenv("HOSTNAME_LOOKUP"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			log.Fatalf("Invalid HOSTNAME_LOOKUP: %v", err)
+		}
+		geoIPService.hostnameDefault = enabled
+	}
+	if raw := os.Getenv("HOSTNAME_TIMEOUT"); raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("Invalid HOSTNAME_TIMEOUT: %v", err)
+		}
+		geoIPService.hostnameTimeout = timeout
+	}
+
 	// Create Gin router
 	r := gin.Default()
 
@@ -51,15 +151,41 @@ func main() {
 		})
 	})
 
+	// Root: same content negotiation as /myip, so a bare `curl` gets just
+	// the caller's IP as plain text while `Accept: application/json` gets
+	// the full response.
+	r.GET("/", geoIPService.GetClientIP)
+
 	// GeoIP lookup endpoint
 	r.GET("/:ip", geoIPService.LookupIP)
 
 	// Get client IP info
 	r.GET("/myip", geoIPService.GetClientIP)
 
+	// Plain-text per-field endpoints for CLI consumers, e.g. curl .../city
+	for path := range fieldProjectors {
+		r.GET(path, geoIPService.FieldLookup)
+	}
+
 	// ForwardAuth endpoint for Traefik
 	r.GET("/lookup", geoIPService.ForwardAuthLookup)
 
+	// Rule-driven allow/deny/tag decision endpoint
+	r.GET("/decide", geoIPService.DecideRequest)
+
+	// ASN membership check and CIDR prefix lookup
+	r.GET("/asn/match", geoIPService.MatchASNRequest)
+	r.GET("/cidr", geoIPService.CIDRLookup)
+
+	// Bulk lookup endpoint, streams one NDJSON result per IP
+	r.POST("/lookup/bulk", geoIPService.BulkLookup)
+
+	// Manual trigger for a hot reload of the MaxMind databases
+	r.POST("/admin/reload", geoIPService.AdminReload)
+
+	// Prometheus metrics
+	r.GET("/metrics", MetricsHandler())
+
 	// Set up graceful shutdown
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -73,6 +199,28 @@ func main() {
 		}
 	}()
 
+	// SIGHUP triggers a hot reload of the MaxMind databases
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("Received SIGHUP, reloading GeoIP databases...")
+			if reloadable, ok := provider.(Reloadable); ok {
+				if err := reloadable.Reload(); err != nil {
+					log.Printf("SIGHUP reload failed: %v", err)
+				}
+				if geoIPService.cache != nil {
+					geoIPService.cache.Reset()
+				}
+			}
+			if geoIPService.rules != nil {
+				if err := geoIPService.rules.Reload(); err != nil {
+					log.Printf("SIGHUP rule file reload failed: %v", err)
+				}
+			}
+		}
+	}()
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// pollInterval is the fallback cadence used to check the mmdb files' mtimes
+// when fsnotify can't be set up (e.g. the data directory lives on a
+// filesystem that doesn't support inotify). Only a newer mtime triggers a
+// reload; a change in file size alone does not.
+const pollInterval = 30 * time.Second
+
+// dbSet bundles one generation of MaxMind readers together so they can be
+// swapped in as a single atomic unit when the underlying files are reloaded.
+// refs tracks in-flight lookups against this generation; the readers are
+// only closed once the count drops to zero, so a reload never unmaps the
+// mmdb out from under a lookup that's still running.
+type dbSet struct {
+	cityDB   *geoip2.Reader
+	asnDB    *geoip2.Reader
+	asnRawDB *maxminddb.Reader
+
+	cityBuildEpoch int64
+	asnBuildEpoch  int64
+
+	refs sync.WaitGroup
+}
+
+// acquire marks the start of a lookup against this generation. Every
+// acquire must be paired with a release.
+func (s *dbSet) acquire() {
+	s.refs.Add(1)
+}
+
+// release marks the end of a lookup against this generation. Once a
+// retired generation's refcount reaches zero, closeWhenIdle closes it.
+func (s *dbSet) release() {
+	s.refs.Done()
+}
+
+// closeWhenIdle blocks until every acquire on this (retired) generation has
+// been released, then closes its readers. It must only be called on a
+// generation that has already been swapped out.
+func (s *dbSet) closeWhenIdle() error {
+	s.refs.Wait()
+	return s.Close()
+}
+
+// MaxMindProvider is the GeoProvider backed by local GeoLite2 mmdb files. It
+// watches dataDir for updates and atomically swaps in new readers, so a
+// weekly MaxMind refresh never drops an in-flight lookup.
+type MaxMindProvider struct {
+	dataDir string
+	db      atomic.Pointer[dbSet]
+	stopCh  chan struct{}
+}
+
+// NewMaxMindProvider opens the GeoLite2 City/ASN databases in dataDir and
+// starts watching it for updates.
+func NewMaxMindProvider(dataDir string) (*MaxMindProvider, error) {
+	set, err := loadDBSet(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MaxMindProvider{
+		dataDir: dataDir,
+		stopCh:  make(chan struct{}),
+	}
+	p.db.Store(set)
+	updateBuildEpochGauges(set)
+	p.startWatching()
+
+	return p, nil
+}
+
+// Lookup implements GeoProvider.
+func (p *MaxMindProvider) Lookup(ip net.IP) (*GeoIPResponse, error) {
+	set := p.db.Load()
+	set.acquire()
+	defer set.release()
+
+	cityRecord, err := set.lookupCity(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	response := NewGeoIPResponse(ip.String(), cityRecord)
+	AddASNInformation(&response, ip, set)
+	set.annotateMeta(&response)
+
+	return &response, nil
+}
+
+// LookupASN implements GeoProvider.
+func (p *MaxMindProvider) LookupASN(ip net.IP) (*ASNInfo, error) {
+	set := p.db.Load()
+	set.acquire()
+	defer set.release()
+
+	asnRecord, err := set.lookupASN(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ASNInfo{ASN: asnRecord.AutonomousSystemNumber, Org: asnRecord.AutonomousSystemOrganization}
+	if network, err := set.lookupNetwork(ip); err == nil {
+		info.Network = network.String()
+	}
+	return info, nil
+}
+
+// LookupNetwork implements CIDRLookupProvider.
+func (p *MaxMindProvider) LookupNetwork(ip net.IP) (*net.IPNet, error) {
+	set := p.db.Load()
+	set.acquire()
+	defer set.release()
+	return set.lookupNetwork(ip)
+}
+
+// Close implements GeoProvider: it stops the reload watcher and closes the
+// current database generation.
+func (p *MaxMindProvider) Close() error {
+	close(p.stopCh)
+
+	set := p.db.Load()
+	if set == nil {
+		return nil
+	}
+	return set.Close()
+}
+
+// Reload implements Reloadable: it opens a new database generation from
+// dataDir, verifies it, and atomically swaps it in behind db. In-flight
+// requests holding the previous generation's pointer keep using it via its
+// refcount; the old readers are only closed once every such lookup has
+// returned.
+func (p *MaxMindProvider) Reload() error {
+	newSet, err := loadDBSet(p.dataDir)
+	if err != nil {
+		return err
+	}
+
+	old := p.db.Swap(newSet)
+	updateBuildEpochGauges(newSet)
+	log.Printf("GeoIP databases reloaded (city build_epoch=%d, asn build_epoch=%d)",
+		newSet.cityBuildEpoch, newSet.asnBuildEpoch)
+
+	if old != nil {
+		go func(old *dbSet) {
+			if err := old.closeWhenIdle(); err != nil {
+				log.Printf("error closing previous GeoIP database generation: %v", err)
+			}
+		}(old)
+	}
+
+	return nil
+}
+
+// loadDBSet opens a fresh City and ASN reader pair from dataDir.
+func loadDBSet(dataDir string) (*dbSet, error) {
+	cityDB, err := geoip2.Open(dataDir + "/GeoLite2-City.mmdb")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open City database: %w", err)
+	}
+
+	asnDB, err := geoip2.Open(dataDir + "/GeoLite2-ASN.mmdb")
+	if err != nil {
+		cityDB.Close()
+		return nil, fmt.Errorf("failed to open ASN database: %w", err)
+	}
+
+	asnRawDB, err := maxminddb.Open(dataDir + "/GeoLite2-ASN.mmdb")
+	if err != nil {
+		cityDB.Close()
+		asnDB.Close()
+		return nil, fmt.Errorf("failed to open ASN raw database: %w", err)
+	}
+
+	set := &dbSet{
+		cityDB:         cityDB,
+		asnDB:          asnDB,
+		asnRawDB:       asnRawDB,
+		cityBuildEpoch: int64(cityDB.Metadata().BuildEpoch),
+		asnBuildEpoch:  int64(asnDB.Metadata().BuildEpoch),
+	}
+
+	// Verify the new handles actually answer a lookup before we let callers
+	// near them; a truncated or half-written mmdb should never be swapped in.
+	if _, err := cityDB.City(net.ParseIP("1.1.1.1")); err != nil {
+		set.Close()
+		return nil, fmt.Errorf("City database failed verification lookup: %w", err)
+	}
+	if _, err := asnDB.ASN(net.ParseIP("1.1.1.1")); err != nil {
+		set.Close()
+		return nil, fmt.Errorf("ASN database failed verification lookup: %w", err)
+	}
+
+	return set, nil
+}
+
+// Close closes every reader in the set
+func (s *dbSet) Close() error {
+	var err1, err2, err3 error
+	if s.cityDB != nil {
+		err1 = s.cityDB.Close()
+	}
+	if s.asnDB != nil {
+		err2 = s.asnDB.Close()
+	}
+	if s.asnRawDB != nil {
+		err3 = s.asnRawDB.Close()
+	}
+
+	if err1 != nil {
+		return err1
+	}
+	if err2 != nil {
+		return err2
+	}
+	return err3
+}
+
+// annotateMeta stamps a response with the build-epoch/node-count of the
+// database generation that produced it, so clients can tell reloads apart.
+func (s *dbSet) annotateMeta(response *GeoIPResponse) {
+	response.CityDB = &DatabaseMeta{
+		BuildEpoch: s.cityBuildEpoch,
+		NodeCount:  s.cityDB.Metadata().NodeCount,
+	}
+	response.ASNDB = &DatabaseMeta{
+		BuildEpoch: s.asnBuildEpoch,
+		NodeCount:  s.asnDB.Metadata().NodeCount,
+	}
+}
+
+// lookupCity looks up ip in the City database, recording its latency and
+// hit/miss/error outcome.
+func (s *dbSet) lookupCity(ip net.IP) (*geoip2.City, error) {
+	start := time.Now()
+	record, err := s.cityDB.City(ip)
+	lookupDuration.WithLabelValues("city").Observe(time.Since(start).Seconds())
+
+	switch {
+	case err != nil:
+		lookupsTotal.WithLabelValues("error", "city").Inc()
+	case record.Country.IsoCode == "":
+		lookupsTotal.WithLabelValues("miss", "city").Inc()
+	default:
+		lookupsTotal.WithLabelValues("hit", "city").Inc()
+	}
+	return record, err
+}
+
+// lookupASN looks up ip in the ASN database, recording its latency and
+// hit/miss/error outcome.
+func (s *dbSet) lookupASN(ip net.IP) (*geoip2.ASN, error) {
+	start := time.Now()
+	record, err := s.asnDB.ASN(ip)
+	lookupDuration.WithLabelValues("asn").Observe(time.Since(start).Seconds())
+
+	switch {
+	case err != nil:
+		lookupsTotal.WithLabelValues("error", "asn").Inc()
+	case record.AutonomousSystemNumber == 0:
+		lookupsTotal.WithLabelValues("miss", "asn").Inc()
+	default:
+		lookupsTotal.WithLabelValues("hit", "asn").Inc()
+	}
+	return record, err
+}
+
+// lookupNetwork returns the enclosing network prefix for ip from the raw
+// ASN database reader.
+func (s *dbSet) lookupNetwork(ip net.IP) (*net.IPNet, error) {
+	if s.asnRawDB == nil {
+		return nil, fmt.Errorf("ASN raw database not available")
+	}
+	var asnData map[string]interface{}
+	network, ok, err := s.asnRawDB.LookupNetwork(ip, &asnData)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || network == nil {
+		return nil, fmt.Errorf("no matching network found for %s", ip)
+	}
+	return network, nil
+}
+
+// NewGeoIPResponse creates a new GeoIPResponse from a city record and IP string
+func NewGeoIPResponse(ipStr string, cityRecord *geoip2.City) GeoIPResponse {
+	response := GeoIPResponse{
+		IP:             ipStr,
+		Country:        cityRecord.Country.Names["en"],
+		CountryCode:    cityRecord.Country.IsoCode,
+		City:           cityRecord.City.Names["en"],
+		PostalCode:     cityRecord.Postal.Code,
+		Latitude:       cityRecord.Location.Latitude,
+		Longitude:      cityRecord.Location.Longitude,
+		AccuracyRadius: cityRecord.Location.AccuracyRadius,
+		TimeZone:       cityRecord.Location.TimeZone,
+	}
+
+	if response.CountryCode != "" {
+		isEU := cityRecord.Country.IsInEuropeanUnion
+		response.CountryIsEU = &isEU
+	}
+
+	// Add region information if available
+	if len(cityRecord.Subdivisions) > 0 {
+		response.Region = cityRecord.Subdivisions[0].Names["en"]
+		response.RegionCode = cityRecord.Subdivisions[0].IsoCode
+	}
+
+	return response
+}
+
+// AddASNInformation adds ASN information to a GeoIPResponse using the given
+// database generation
+func AddASNInformation(response *GeoIPResponse, ip net.IP, set *dbSet) {
+	asnRecord, asnErr := set.lookupASN(ip)
+	if asnErr != nil {
+		return
+	}
+
+	response.ASN = asnRecord.AutonomousSystemNumber
+	response.ASNOrg = asnRecord.AutonomousSystemOrganization
+
+	if network, err := set.lookupNetwork(ip); err == nil {
+		response.ASNNetwork = network.String()
+	}
+}
+
+// startWatching launches the background goroutine that watches dataDir for
+// MaxMind updates, preferring fsnotify and falling back to polling.
+func (p *MaxMindProvider) startWatching() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("fsnotify unavailable, falling back to polling every %s: %v", pollInterval, err)
+		go p.pollLoop()
+		return
+	}
+
+	if err := watcher.Add(p.dataDir); err != nil {
+		log.Printf("failed to watch %s, falling back to polling every %s: %v", p.dataDir, pollInterval, err)
+		watcher.Close()
+		go p.pollLoop()
+		return
+	}
+
+	go p.watchLoop(watcher)
+}
+
+func (p *MaxMindProvider) watchLoop(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := p.Reload(); err != nil {
+				log.Printf("GeoIP reload triggered by %s failed: %v", event.Name, err)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("fsnotify watcher error: %v", err)
+		}
+	}
+}
+
+func (p *MaxMindProvider) pollLoop() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	lastMod := p.dataDirModTime()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			mod := p.dataDirModTime()
+			if mod.After(lastMod) {
+				lastMod = mod
+				if err := p.Reload(); err != nil {
+					log.Printf("GeoIP poll reload failed: %v", err)
+				}
+			}
+		}
+	}
+}
+
+func (p *MaxMindProvider) dataDirModTime() time.Time {
+	var latest time.Time
+	for _, name := range []string{"GeoLite2-City.mmdb", "GeoLite2-ASN.mmdb"} {
+		info, err := os.Stat(p.dataDir + "/" + name)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// AdminReload handles POST /admin/reload, letting operators trigger a
+// database reload without sending SIGHUP to the process. It returns 501 when the
+// configured provider doesn't support reloading (e.g. a remote API backend).
+func (g *GeoIPService) AdminReload(c *gin.Context) {
+	reloadable, ok := g.provider.(Reloadable)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "configured provider does not support reloading"})
+		return
+	}
+
+	if err := reloadable.Reload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if g.cache != nil {
+		// Cached responses carry the previous generation's build epoch and
+		// ASN data, so they must not survive a reload.
+		g.cache.Reset()
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "reloaded"})
+}
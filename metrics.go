@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	lookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "geoip_lookups_total",
+		Help: "Total number of MaxMind database lookups, by outcome and database.",
+	}, []string{"result", "db"})
+
+	lookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "geoip_lookup_duration_seconds",
+		Help:    "Latency of a single MaxMind database lookup, excluding HTTP overhead.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"db"})
+
+	dbBuildEpoch = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "geoip_db_build_epoch_seconds",
+		Help: "Build epoch of the currently loaded MaxMind database, by database.",
+	}, []string{"db"})
+
+	clientIPSourceTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "geoip_client_ip_source_total",
+		Help: "Number of times the client IP was resolved from each header source.",
+	}, []string{"source"})
+
+	cacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_cache_hits_total",
+		Help: "Total number of lookup cache hits.",
+	})
+
+	cacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_cache_misses_total",
+		Help: "Total number of lookup cache misses.",
+	})
+
+	cacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "geoip_cache_size",
+		Help: "Current number of entries held in the lookup cache.",
+	})
+)
+
+// updateBuildEpochGauges reflects a database generation's build epoch into
+// the gauges Prometheus scrapes.
+func updateBuildEpochGauges(set *dbSet) {
+	dbBuildEpoch.WithLabelValues("city").Set(float64(set.cityBuildEpoch))
+	dbBuildEpoch.WithLabelValues("asn").Set(float64(set.asnBuildEpoch))
+}
+
+// MetricsHandler exposes the Prometheus text format at /metrics.
+func MetricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// ASNInfo is the autonomous-system data a GeoProvider can attach to a
+// lookup, independent of which backend answered it.
+type ASNInfo struct {
+	ASN     uint
+	Org     string
+	Network string
+}
+
+// GeoProvider is the backend abstraction behind GeoIPService: it answers a
+// single IP's geo/ASN data, whether that data is sourced from local mmdb
+// files, a remote HTTP API, or a combination of the two.
+type GeoProvider interface {
+	// Lookup returns the full geo response for ip, including ASN fields
+	// when the backend can supply them in one call.
+	Lookup(ip net.IP) (*GeoIPResponse, error)
+	// LookupASN returns just the ASN data for ip, for callers (ASN
+	// membership checks, CIDR lookups) that don't need the rest of the
+	// response.
+	LookupASN(ip net.IP) (*ASNInfo, error)
+	Close() error
+}
+
+// CIDRLookupProvider is implemented by providers that can resolve the
+// enclosing network prefix for an IP, not just its ASN/org. Local mmdb
+// files support this; most remote APIs don't expose it.
+type CIDRLookupProvider interface {
+	LookupNetwork(ip net.IP) (*net.IPNet, error)
+}
+
+// Reloadable is implemented by providers that can hot-reload their backing
+// data without a restart, such as re-reading local mmdb files after a
+// MaxMind update.
+type Reloadable interface {
+	Reload() error
+}
+
+// ProviderConfig selects and configures a GeoProvider. Only the fields
+// relevant to Name need be set.
+type ProviderConfig struct {
+	Name    string // "maxmind", "ipstack", or "maxmind+ipstack"
+	DataDir string // maxmind: directory holding the GeoLite2 mmdb files
+	APIKey  string // ipstack: API access key
+}
+
+// NewGeoProvider builds the GeoProvider selected by cfg.Name.
+func NewGeoProvider(cfg ProviderConfig) (GeoProvider, error) {
+	switch cfg.Name {
+	case "", "maxmind":
+		return NewMaxMindProvider(cfg.DataDir)
+
+	case "ipstack":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("ipstack provider requires an API key")
+		}
+		return NewIPStackProvider(cfg.APIKey), nil
+
+	case "maxmind+ipstack":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("maxmind+ipstack provider requires an API key")
+		}
+		primary, err := NewMaxMindProvider(cfg.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		return NewCompositeProvider(primary, NewIPStackProvider(cfg.APIKey)), nil
+
+	default:
+		return nil, fmt.Errorf("unknown provider %q (want maxmind, ipstack, or maxmind+ipstack)", cfg.Name)
+	}
+}
+
+// CompositeProvider tries primary first and falls back to secondary when
+// primary errors or has no record for the IP (an empty country code, the
+// same "miss" signal dbSet.lookupCity uses internally).
+type CompositeProvider struct {
+	primary   GeoProvider
+	secondary GeoProvider
+}
+
+// NewCompositeProvider returns a provider that prefers primary, falling
+// back to secondary on a miss.
+func NewCompositeProvider(primary, secondary GeoProvider) *CompositeProvider {
+	return &CompositeProvider{primary: primary, secondary: secondary}
+}
+
+func (p *CompositeProvider) Lookup(ip net.IP) (*GeoIPResponse, error) {
+	response, err := p.primary.Lookup(ip)
+	if err == nil && response.CountryCode != "" {
+		return response, nil
+	}
+	return p.secondary.Lookup(ip)
+}
+
+func (p *CompositeProvider) LookupASN(ip net.IP) (*ASNInfo, error) {
+	info, err := p.primary.LookupASN(ip)
+	if err == nil && info.ASN != 0 {
+		return info, nil
+	}
+	return p.secondary.LookupASN(ip)
+}
+
+func (p *CompositeProvider) Close() error {
+	err1 := p.primary.Close()
+	err2 := p.secondary.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// LookupNetwork delegates to primary when it supports CIDRLookupProvider
+// (true for the MaxMind-backed primary this composite is built for).
+func (p *CompositeProvider) LookupNetwork(ip net.IP) (*net.IPNet, error) {
+	cidrProvider, ok := p.primary.(CIDRLookupProvider)
+	if !ok {
+		return nil, fmt.Errorf("composite provider's primary does not support CIDR lookups")
+	}
+	return cidrProvider.LookupNetwork(ip)
+}
+
+// Reload delegates to primary when it supports Reloadable (true for the
+// MaxMind-backed primary this composite is built for).
+func (p *CompositeProvider) Reload() error {
+	reloadable, ok := p.primary.(Reloadable)
+	if !ok {
+		return fmt.Errorf("composite provider's primary does not support reloading")
+	}
+	return reloadable.Reload()
+}
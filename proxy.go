@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// parseTrustedProxies parses a comma-separated list of CIDRs (as found in
+// the TRUSTED_PROXIES env var) into the networks getClientIP trusts when
+// walking X-Forwarded-For. A bare IP (no "/") is treated as a /32 or /128.
+func parseTrustedProxies(raw string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseIPHeaders parses a comma-separated list of header names (as found in
+// the IP_HEADERS env var) into the order getClientIP checks them in.
+func parseIPHeaders(raw string) []string {
+	var headers []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			headers = append(headers, entry)
+		}
+	}
+	return headers
+}
+
+// isTrustedProxy reports whether ip falls within any of the trusted networks.
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the host part of remoteAddr, stripping the port if present.
+func remoteIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// resolveTrustedXFF walks the X-Forwarded-For chain (plus the immediate
+// RemoteAddr as its nearest hop) from right to left, skipping over entries
+// that are themselves trusted proxies. The first untrusted hop encountered
+// is the real client address; if every hop is trusted, the leftmost hop is
+// returned as the best-effort answer.
+func resolveTrustedXFF(remoteAddr, xff string, trusted []*net.IPNet) string {
+	hops := make([]string, 0, 4)
+	for _, hop := range strings.Split(xff, ",") {
+		hop = strings.TrimSpace(hop)
+		if hop != "" {
+			hops = append(hops, hop)
+		}
+	}
+	if remoteAddr != "" {
+		hops = append(hops, remoteAddr)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			continue
+		}
+		if !isTrustedProxy(ip, trusted) {
+			return hops[i]
+		}
+	}
+
+	if len(hops) > 0 {
+		return hops[0]
+	}
+	return remoteAddr
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResolveTrustedXFF(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8,172.16.0.0/12")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		expected   string
+	}{
+		{
+			name:       "single trusted hop in front of client",
+			remoteAddr: "10.0.0.1",
+			xff:        "203.0.113.7",
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "client spoofs XFF but RemoteAddr is untrusted",
+			remoteAddr: "203.0.113.99",
+			xff:        "1.2.3.4",
+			expected:   "203.0.113.99",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "172.16.5.5",
+			xff:        "203.0.113.7, 10.0.0.2",
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "every hop trusted falls back to leftmost",
+			remoteAddr: "10.0.0.1",
+			xff:        "10.0.0.3, 10.0.0.2",
+			expected:   "10.0.0.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveTrustedXFF(tt.remoteAddr, tt.xff, trusted)
+			if got != tt.expected {
+				t.Errorf("resolveTrustedXFF(%q, %q) = %q, want %q", tt.remoteAddr, tt.xff, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesBareIP(t *testing.T) {
+	networks, err := parseTrustedProxies("192.168.1.1")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies failed: %v", err)
+	}
+	if len(networks) != 1 {
+		t.Fatalf("expected 1 network, got %d", len(networks))
+	}
+	if ones, _ := networks[0].Mask.Size(); ones != 32 {
+		t.Errorf("expected /32 mask for bare IPv4, got /%d", ones)
+	}
+}
+
+func newTestContext(remoteAddr string, headers map[string]string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	return c
+}
+
+func TestGetClientIPCFConnectingIP(t *testing.T) {
+	cfRanges, err := parseTrustedProxies("198.51.100.0/24")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies failed: %v", err)
+	}
+
+	c := newTestContext("203.0.113.9:1234", map[string]string{"CF-Connecting-IP": "198.51.100.1"})
+	if got := getClientIP(c, nil, nil, true, false, nil); got != "198.51.100.1" {
+		t.Errorf("cfEnabled=true, cfStrict=false: got %q, want 198.51.100.1", got)
+	}
+
+	c = newTestContext("203.0.113.9:1234", map[string]string{"CF-Connecting-IP": "198.51.100.1"})
+	if got := getClientIP(c, nil, nil, false, false, nil); got == "198.51.100.1" {
+		t.Errorf("cfEnabled=false should not trust CF-Connecting-IP, got %q", got)
+	}
+
+	c = newTestContext("203.0.113.9:1234", map[string]string{"CF-Connecting-IP": "198.51.100.1"})
+	if got := getClientIP(c, nil, nil, true, true, cfRanges); got == "198.51.100.1" {
+		t.Errorf("cfStrict=true with a peer outside cfRanges should not trust CF-Connecting-IP, got %q", got)
+	}
+
+	c = newTestContext("198.51.100.5:1234", map[string]string{"CF-Connecting-IP": "198.51.100.1"})
+	if got := getClientIP(c, nil, nil, true, true, cfRanges); got != "198.51.100.1" {
+		t.Errorf("cfStrict=true with a peer inside cfRanges: got %q, want 198.51.100.1", got)
+	}
+}
+
+func TestParseIPHeaders(t *testing.T) {
+	got := parseIPHeaders(" CF-Connecting-IP ,X-Forwarded-For,, X-Real-IP")
+	expected := []string{"CF-Connecting-IP", "X-Forwarded-For", "X-Real-IP"}
+	if len(got) != len(expected) {
+		t.Fatalf("parseIPHeaders = %v, want %v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("parseIPHeaders[%d] = %q, want %q", i, got[i], expected[i])
+		}
+	}
+}
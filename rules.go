@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleAction is the outcome a matched rule produces at ForwardAuth/decide time.
+type RuleAction string
+
+const (
+	RuleActionAllow        RuleAction = "allow"
+	RuleActionDeny         RuleAction = "deny"
+	RuleActionUnauthorized RuleAction = "unauthorized"
+)
+
+// RuleCondition describes the match criteria for a single rule. Within a
+// field the entries are OR'd together; across fields they're AND'd.
+type RuleCondition struct {
+	ASN     []uint   `yaml:"asn"`
+	ASNOrg  []string `yaml:"asn_org"`
+	Country []string `yaml:"country"`
+	CIDR    []string `yaml:"cidr"`
+}
+
+// Rule is one ordered entry in the rule file; the first rule whose `when`
+// matches wins, mirroring how IP-ASN rules are evaluated at the edge.
+type Rule struct {
+	When   RuleCondition `yaml:"when"`
+	Action RuleAction    `yaml:"action"`
+	Tag    string        `yaml:"tag"`
+}
+
+// ruleFile is the top-level shape of the YAML rule file.
+type ruleFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// compiledRule is a Rule with its match criteria pre-processed for fast
+// lookups: ASNs sorted for binary search, org substrings lowercased, and
+// countries collapsed into a set. CIDRs live in the engine's shared tries.
+type compiledRule struct {
+	asns      []uint
+	asnOrgs   []string
+	countries map[string]bool
+	hasCIDR   bool
+	action    RuleAction
+	tag       string
+}
+
+// RuleEngine evaluates ForwardAuth/decide requests against an ordered list
+// of allow/deny/tag rules loaded from a YAML file, reloadable on SIGHUP.
+type RuleEngine struct {
+	path string
+
+	rules atomic.Pointer[[]compiledRule]
+	v4    atomic.Pointer[cidrTrie]
+	v6    atomic.Pointer[cidrTrie]
+}
+
+// NewRuleEngine loads and compiles the rule file at path.
+func NewRuleEngine(path string) (*RuleEngine, error) {
+	e := &RuleEngine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload re-reads and recompiles the rule file, atomically swapping in the
+// new rule set. A parse error leaves the previously loaded rules in place.
+func (e *RuleEngine) Reload() error {
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return fmt.Errorf("failed to read rule file: %w", err)
+	}
+
+	var rf ruleFile
+	if err := yaml.Unmarshal(data, &rf); err != nil {
+		return fmt.Errorf("failed to parse rule file: %w", err)
+	}
+
+	compiled := make([]compiledRule, len(rf.Rules))
+	v4 := newCIDRTrie()
+	v6 := newCIDRTrie()
+
+	for i, r := range rf.Rules {
+		cr := compiledRule{
+			asns:    append([]uint(nil), r.When.ASN...),
+			asnOrgs: make([]string, len(r.When.ASNOrg)),
+			action:  r.Action,
+			tag:     r.Tag,
+		}
+		sort.Slice(cr.asns, func(a, b int) bool { return cr.asns[a] < cr.asns[b] })
+
+		for j, org := range r.When.ASNOrg {
+			cr.asnOrgs[j] = strings.ToLower(org)
+		}
+
+		if len(r.When.Country) > 0 {
+			cr.countries = make(map[string]bool, len(r.When.Country))
+			for _, country := range r.When.Country {
+				cr.countries[strings.ToUpper(country)] = true
+			}
+		}
+
+		for _, cidr := range r.When.CIDR {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("rule %d: invalid CIDR %q: %w", i, cidr, err)
+			}
+			cr.hasCIDR = true
+			if v4IP := network.IP.To4(); v4IP != nil {
+				// Evaluate sends every IPv4 address, v4-mapped ones included,
+				// to the v4 trie, so a v4-mapped IPv6 CIDR is stored there
+				// with its prefix length counted in IPv4 bits.
+				ones, totalBits := network.Mask.Size()
+				if totalBits == 128 {
+					ones -= 96
+				}
+				v4.insert(&net.IPNet{IP: v4IP, Mask: net.CIDRMask(ones, 32)}, i)
+			} else {
+				v6.insert(network, i)
+			}
+		}
+
+		compiled[i] = cr
+	}
+
+	e.rules.Store(&compiled)
+	e.v4.Store(v4)
+	e.v6.Store(v6)
+	return nil
+}
+
+// Decision is the outcome of evaluating a request against the rule set.
+type Decision struct {
+	Matched bool
+	Action  RuleAction
+	Tag     string
+	Rule    int
+}
+
+// Evaluate walks the rule list in order and returns the first match.
+func (e *RuleEngine) Evaluate(ip net.IP, asn uint, asnOrg, country string) Decision {
+	rules := e.rules.Load()
+	if rules == nil {
+		return Decision{}
+	}
+
+	var cidrMatches map[int]bool
+	if ip.To4() != nil {
+		if trie := e.v4.Load(); trie != nil {
+			cidrMatches = trie.matchingRules(ip)
+		}
+	} else if trie := e.v6.Load(); trie != nil {
+		cidrMatches = trie.matchingRules(ip)
+	}
+
+	asnOrgLower := strings.ToLower(asnOrg)
+	countryUpper := strings.ToUpper(country)
+
+	for i, r := range *rules {
+		if len(r.asns) > 0 && !matchASN(r.asns, asn) {
+			continue
+		}
+		if len(r.asnOrgs) > 0 && !matchASNOrg(r.asnOrgs, asnOrgLower) {
+			continue
+		}
+		if r.countries != nil && !r.countries[countryUpper] {
+			continue
+		}
+		if r.hasCIDR && !cidrMatches[i] {
+			continue
+		}
+		return Decision{Matched: true, Action: r.action, Tag: r.tag, Rule: i}
+	}
+
+	return Decision{}
+}
+
+// matchASN reports whether asn is present in the sorted list asns.
+func matchASN(asns []uint, asn uint) bool {
+	i := sort.Search(len(asns), func(i int) bool { return asns[i] >= asn })
+	return i < len(asns) && asns[i] == asn
+}
+
+// matchASNOrg reports whether any configured substring appears in org
+// (already lowercased).
+func matchASNOrg(substrings []string, org string) bool {
+	for _, s := range substrings {
+		if strings.Contains(org, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// cidrTrieNode is one bit of a binary prefix trie.
+type cidrTrieNode struct {
+	children [2]*cidrTrieNode
+	ruleIdxs []int
+}
+
+// cidrTrie is a binary longest-prefix-match trie over raw IP bits. A lookup
+// walks from the root bit by bit and collects every rule whose CIDR covers
+// the address, in O(prefix-length) time regardless of how many rules are
+// loaded.
+type cidrTrie struct {
+	root *cidrTrieNode
+}
+
+func newCIDRTrie() *cidrTrie {
+	return &cidrTrie{root: &cidrTrieNode{}}
+}
+
+func (t *cidrTrie) insert(network *net.IPNet, ruleIdx int) {
+	bits, totalBits := network.Mask.Size()
+	var ip net.IP
+	if totalBits == 32 {
+		ip = network.IP.To4()
+	} else {
+		ip = network.IP.To16()
+	}
+
+	node := t.root
+	for i := 0; i < bits; i++ {
+		bit := ipBit(ip, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.ruleIdxs = append(node.ruleIdxs, ruleIdx)
+}
+
+// matchingRules returns the set of rule indices whose CIDR contains ip.
+func (t *cidrTrie) matchingRules(ip net.IP) map[int]bool {
+	raw := ip.To4()
+	if raw == nil {
+		raw = ip.To16()
+	}
+	if raw == nil {
+		return nil
+	}
+
+	matches := make(map[int]bool)
+	node := t.root
+	for i := 0; i < len(raw)*8; i++ {
+		for _, idx := range node.ruleIdxs {
+			matches[idx] = true
+		}
+		next := node.children[ipBit(raw, i)]
+		if next == nil {
+			return matches
+		}
+		node = next
+	}
+	for _, idx := range node.ruleIdxs {
+		matches[idx] = true
+	}
+
+	return matches
+}
+
+func ipBit(ip net.IP, i int) int {
+	return int((ip[i/8] >> uint(7-i%8)) & 1)
+}
+
+// DecideRequest handles GET /decide: it looks up the caller's ASN/country
+// and returns the rule engine's verdict as a bare status code plus an
+// X-GeoIP-Tag header, without a response body.
+func (g *GeoIPService) DecideRequest(c *gin.Context) {
+	clientIP := getClientIP(c, g.trustedProxies, g.ipHeaders, g.trustCFConnectingIP, g.cfStrict, g.cfRanges)
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	if g.rules == nil {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	response, lookupErr := g.provider.Lookup(ip)
+	if lookupErr != nil {
+		response = &GeoIPResponse{}
+	}
+
+	decision := g.rules.Evaluate(ip, response.ASN, response.ASNOrg, response.CountryCode)
+	if decision.Matched && decision.Tag != "" {
+		c.Header("X-GeoIP-Tag", decision.Tag)
+	}
+
+	switch {
+	case !decision.Matched && lookupErr != nil:
+		// A lookup failure must not bypass deny rules: with nothing matching
+		// the zero-value evaluation, fail closed instead of forwarding to
+		// origin, same as ForwardAuthLookup.
+		c.Status(http.StatusBadGateway)
+	case !decision.Matched, decision.Action == RuleActionAllow:
+		c.Status(http.StatusOK)
+	case decision.Action == RuleActionUnauthorized:
+		c.Status(http.StatusUnauthorized)
+	default:
+		c.Status(http.StatusForbidden)
+	}
+}
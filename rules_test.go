@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// erroringProvider fails every lookup, for testing the fail-closed paths
+// that kick in when the configured provider can't be reached.
+type erroringProvider struct{}
+
+func (erroringProvider) Lookup(ip net.IP) (*GeoIPResponse, error) {
+	return nil, errors.New("provider unavailable")
+}
+func (erroringProvider) LookupASN(ip net.IP) (*ASNInfo, error) {
+	return nil, errors.New("provider unavailable")
+}
+func (erroringProvider) Close() error { return nil }
+
+func writeRuleFile(t *testing.T, contents string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("failed to write rule file: %v", err)
+	}
+	return path
+}
+
+func TestRuleEngineCIDRMatch(t *testing.T) {
+	path := writeRuleFile(t, `
+rules:
+  - when:
+      cidr: ["203.0.113.0/24"]
+    action: deny
+    tag: hosting
+  - when:
+      cidr: ["2001:db8::/32"]
+    action: deny
+    tag: hosting-v6
+`)
+
+	engine, err := NewRuleEngine(path)
+	if err != nil {
+		t.Fatalf("NewRuleEngine failed: %v", err)
+	}
+
+	decision := engine.Evaluate(net.ParseIP("203.0.113.5"), 0, "", "")
+	if !decision.Matched || decision.Action != RuleActionDeny || decision.Tag != "hosting" {
+		t.Errorf("expected v4 CIDR match to deny/hosting, got %+v", decision)
+	}
+
+	decision = engine.Evaluate(net.ParseIP("2001:db8::1"), 0, "", "")
+	if !decision.Matched || decision.Action != RuleActionDeny || decision.Tag != "hosting-v6" {
+		t.Errorf("expected v6 CIDR match to deny/hosting-v6, got %+v", decision)
+	}
+
+	decision = engine.Evaluate(net.ParseIP("198.51.100.1"), 0, "", "")
+	if decision.Matched {
+		t.Errorf("expected no match for unrelated IP, got %+v", decision)
+	}
+}
+
+// A v4-mapped IPv6 CIDR like "::ffff:1.2.3.0/120" has a /32-To4-succeeding
+// IP but a 128-bit mask; previously this sent a 4-byte ip slice into a loop
+// bounded by the 120-bit prefix length and panicked past byte 4.
+func TestRuleEngineV4MappedCIDRDoesNotPanic(t *testing.T) {
+	path := writeRuleFile(t, `
+rules:
+  - when:
+      cidr: ["::ffff:1.2.3.0/120"]
+    action: deny
+    tag: mapped
+`)
+
+	engine, err := NewRuleEngine(path)
+	if err != nil {
+		t.Fatalf("NewRuleEngine failed: %v", err)
+	}
+
+	decision := engine.Evaluate(net.ParseIP("::ffff:1.2.3.4"), 0, "", "")
+	if !decision.Matched || decision.Tag != "mapped" {
+		t.Errorf("expected v4-mapped CIDR match, got %+v", decision)
+	}
+}
+
+func TestRuleEngineASNAndCountryMatch(t *testing.T) {
+	path := writeRuleFile(t, `
+rules:
+  - when:
+      asn: [13335]
+    action: allow
+    tag: cloudflare
+  - when:
+      country: ["RU", "CN"]
+    action: unauthorized
+`)
+
+	engine, err := NewRuleEngine(path)
+	if err != nil {
+		t.Fatalf("NewRuleEngine failed: %v", err)
+	}
+
+	decision := engine.Evaluate(net.ParseIP("1.1.1.1"), 13335, "Cloudflare, Inc.", "US")
+	if !decision.Matched || decision.Action != RuleActionAllow || decision.Tag != "cloudflare" {
+		t.Errorf("expected ASN match to allow/cloudflare, got %+v", decision)
+	}
+
+	decision = engine.Evaluate(net.ParseIP("1.2.3.4"), 0, "", "RU")
+	if !decision.Matched || decision.Action != RuleActionUnauthorized {
+		t.Errorf("expected country match to unauthorized, got %+v", decision)
+	}
+
+	decision = engine.Evaluate(net.ParseIP("1.2.3.4"), 0, "", "FR")
+	if decision.Matched {
+		t.Errorf("expected no match for unlisted country, got %+v", decision)
+	}
+}
+
+func newDecideTestContext(t *testing.T) *gin.Context {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	req := httptest.NewRequest(http.MethodGet, "/decide", nil)
+	req.RemoteAddr = "203.0.113.5:1234"
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = req
+	return c
+}
+
+// TestDecideRequestFailsClosedOnLookupError mirrors ForwardAuthLookup's
+// fail-closed behavior: a lookup error with no catch-all rule matching the
+// zero-value evaluation must not fail open to 200 OK.
+func TestDecideRequestFailsClosedOnLookupError(t *testing.T) {
+	path := writeRuleFile(t, `
+rules:
+  - when:
+      country: ["RU"]
+    action: deny
+`)
+	engine, err := NewRuleEngine(path)
+	if err != nil {
+		t.Fatalf("NewRuleEngine failed: %v", err)
+	}
+
+	g := &GeoIPService{provider: erroringProvider{}, rules: engine}
+	c := newDecideTestContext(t)
+
+	g.DecideRequest(c)
+
+	if c.Writer.Status() != http.StatusBadGateway {
+		t.Errorf("DecideRequest status = %d, want %d (fail closed on lookup error)", c.Writer.Status(), http.StatusBadGateway)
+	}
+}
+
+// TestDecideRequestCatchAllRuleStillFiresOnLookupError confirms a rule that
+// matches the zero-value ASN/country (e.g. a catch-all deny) still takes
+// priority over the fail-closed default.
+func TestDecideRequestCatchAllRuleStillFiresOnLookupError(t *testing.T) {
+	path := writeRuleFile(t, `
+rules:
+  - when:
+      country: [""]
+    action: unauthorized
+`)
+	engine, err := NewRuleEngine(path)
+	if err != nil {
+		t.Fatalf("NewRuleEngine failed: %v", err)
+	}
+
+	g := &GeoIPService{provider: erroringProvider{}, rules: engine}
+	c := newDecideTestContext(t)
+
+	g.DecideRequest(c)
+
+	if c.Writer.Status() != http.StatusUnauthorized {
+		t.Errorf("DecideRequest status = %d, want %d (catch-all rule should still fire)", c.Writer.Status(), http.StatusUnauthorized)
+	}
+}
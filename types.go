@@ -1,84 +1,91 @@
 package main
 
 import (
-	"fmt"
+	"encoding/xml"
 	"net"
-
-	"github.com/oschwald/geoip2-golang"
-	"github.com/oschwald/maxminddb-golang"
+	"time"
 )
 
-// GeoIPService handles GeoIP lookups using MaxMind databases
+// GeoIPService answers geo/ASN lookups via a pluggable GeoProvider backend,
+// layering cross-cutting concerns (caching, hostname resolution, rule-based
+// allow/deny decisions) on top that apply regardless of which provider is
+// configured.
 type GeoIPService struct {
-	cityDB   *geoip2.Reader
-	asnDB    *geoip2.Reader
-	asnRawDB *maxminddb.Reader
-}
+	provider GeoProvider
 
-// GeoIPResponse represents the response structure for GeoIP lookups
-type GeoIPResponse struct {
-	IP             string  `json:"ip"`
-	Country        string  `json:"country"`
-	CountryCode    string  `json:"country_code"`
-	Region         string  `json:"region"`
-	RegionCode     string  `json:"region_code"`
-	City           string  `json:"city"`
-	PostalCode     string  `json:"postal_code"`
-	Latitude       float64 `json:"latitude"`
-	Longitude      float64 `json:"longitude"`
-	AccuracyRadius uint16  `json:"accuracy_radius,omitempty"`
-	TimeZone       string  `json:"timezone"`
-	ASN            uint    `json:"asn,omitempty"`
-	ASNOrg         string  `json:"asn_org,omitempty"`
-	ASNNetwork     string  `json:"asn_network,omitempty"`
-}
+	// rules is nil when no rule file was configured, in which case
+	// ForwardAuthLookup/DecideRequest behave as pure passthroughs.
+	rules *RuleEngine
+
+	// trustedProxies gates how much of X-Forwarded-For getClientIP trusts;
+	// empty means no hop is trusted, so XFF falls back to a best-effort
+	// (spoofable) heuristic.
+	trustedProxies []*net.IPNet
+
+	// ipHeaders overrides the order getClientIP checks headers in; empty
+	// means defaultIPHeaders, as set by IP_HEADERS.
+	ipHeaders []string
 
-// NewGeoIPResponse creates a new GeoIPResponse from a city record and IP string
-func NewGeoIPResponse(ipStr string, cityRecord *geoip2.City) GeoIPResponse {
-	response := GeoIPResponse{
-		IP:             ipStr,
-		Country:        cityRecord.Country.Names["en"],
-		CountryCode:    cityRecord.Country.IsoCode,
-		City:           cityRecord.City.Names["en"],
-		PostalCode:     cityRecord.Postal.Code,
-		Latitude:       cityRecord.Location.Latitude,
-		Longitude:      cityRecord.Location.Longitude,
-		AccuracyRadius: cityRecord.Location.AccuracyRadius,
-		TimeZone:       cityRecord.Location.TimeZone,
-	}
+	// trustCFConnectingIP enables the CF-Connecting-IP shortcut in
+	// getClientIP; defaults to true (the server's historical behavior), set
+	// by TRUST_CF_CONNECTING_IP.
+	trustCFConnectingIP bool
 
-	// Add region information if available
-	if len(cityRecord.Subdivisions) > 0 {
-		response.Region = cityRecord.Subdivisions[0].Names["en"]
-		response.RegionCode = cityRecord.Subdivisions[0].IsoCode
-	}
+	// cfStrict additionally requires the immediate peer (RemoteAddr) to fall
+	// within cfRanges before CF-Connecting-IP is trusted, closing the
+	// spoofing hole where a client sets the header directly against a
+	// service that isn't network-isolated behind Cloudflare. Set by
+	// CF_STRICT.
+	cfStrict bool
 
-	return response
+	// cfRanges is Cloudflare's published IPv4+IPv6 ranges, fetched once at
+	// startup (see fetchCloudflareRanges) when CF_STRICT is enabled. Unused
+	// when cfStrict is false.
+	cfRanges []*net.IPNet
+
+	// cache is nil when CACHE_SIZE=0, in which case every lookup goes
+	// straight to the provider.
+	cache *LookupCache
+
+	// hostnameDefault makes reverse DNS resolution run even without
+	// ?hostname=true; hostnameTimeout bounds how long it may block a
+	// response (falls back to defaultHostnameTimeout when zero).
+	hostnameDefault bool
+	hostnameTimeout time.Duration
+}
+
+// DatabaseMeta reports which generation of a local MaxMind database
+// answered a lookup, so clients can observe when a hot reload has taken
+// effect. It is left nil by providers with no notion of database
+// generations (e.g. a remote HTTP backend).
+type DatabaseMeta struct {
+	BuildEpoch int64 `json:"build_epoch"`
+	NodeCount  uint  `json:"node_count"`
 }
 
-// AddASNInformation adds ASN information to a GeoIPResponse
-func AddASNInformation(response *GeoIPResponse, ip net.IP, ipStr string, asnDB *geoip2.Reader, asnRawDB *maxminddb.Reader) {
-	// Get ASN information
-	asnRecord, asnErr := asnDB.ASN(ip)
+// GeoIPResponse represents the response structure for GeoIP lookups
+type GeoIPResponse struct {
+	XMLName        xml.Name `json:"-" xml:"geoip"`
+	IP             string   `json:"ip" xml:"ip"`
+	Country        string   `json:"country" xml:"country"`
+	CountryCode    string   `json:"country_code" xml:"country_code"`
+	Region         string   `json:"region" xml:"region"`
+	RegionCode     string   `json:"region_code" xml:"region_code"`
+	City           string   `json:"city" xml:"city"`
+	PostalCode     string   `json:"postal_code" xml:"postal_code"`
+	Latitude       float64  `json:"latitude" xml:"latitude"`
+	Longitude      float64  `json:"longitude" xml:"longitude"`
+	AccuracyRadius uint16   `json:"accuracy_radius,omitempty" xml:"accuracy_radius,omitempty"`
+	TimeZone       string   `json:"timezone" xml:"timezone"`
+	Geohash        string   `json:"geohash,omitempty" xml:"geohash,omitempty"`
+	CountryIsEU    *bool    `json:"country_is_eu,omitempty" xml:"country_is_eu,omitempty"`
+	Hostname       string   `json:"hostname,omitempty" xml:"hostname,omitempty"`
+	ASN            uint     `json:"asn,omitempty" xml:"asn,omitempty"`
+	ASNOrg         string   `json:"asn_org,omitempty" xml:"asn_org,omitempty"`
+	ASNNetwork     string   `json:"asn_network,omitempty" xml:"asn_network,omitempty"`
 
-	// Add ASN information if available
-	if asnErr == nil {
-		response.ASN = asnRecord.AutonomousSystemNumber
-		response.ASNOrg = asnRecord.AutonomousSystemOrganization
+	CityDB *DatabaseMeta `json:"city_db,omitempty" xml:"city_db,omitempty"`
+	ASNDB  *DatabaseMeta `json:"asn_db,omitempty" xml:"asn_db,omitempty"`
 
-		// Get ASN network information using the underlying maxminddb reader
-		if asnRawDB != nil {
-			var asnData map[string]interface{}
-			if network, ok, err := asnRawDB.LookupNetwork(ip, &asnData); err == nil && ok && network != nil {
-				response.ASNNetwork = network.String()
-				fmt.Printf("DEBUG: ASN Network for %s: %s\n", ipStr, network.String())
-			} else {
-				fmt.Printf("DEBUG: ASN Network lookup failed for %s: err=%v, ok=%v, network=%v\n", ipStr, err, ok, network)
-			}
-		} else {
-			fmt.Printf("DEBUG: asnRawDB is nil\n")
-		}
-	} else {
-		fmt.Printf("DEBUG: ASN lookup failed for %s: %v\n", ipStr, asnErr)
-	}
+	UserAgent *UserAgentInfo `json:"user_agent,omitempty" xml:"user_agent,omitempty"`
 }
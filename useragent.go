@@ -0,0 +1,88 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserAgentInfo is a best-effort parse of the caller's User-Agent header.
+// It's only attached to a response when wantUserAgent says so (see
+// RespondGeoIP), keeping the common JSON-less path free of the extra work.
+type UserAgentInfo struct {
+	Product   string `json:"product,omitempty" xml:"product,omitempty"`
+	Version   string `json:"version,omitempty" xml:"version,omitempty"`
+	OS        string `json:"os,omitempty" xml:"os,omitempty"`
+	OSVersion string `json:"os_version,omitempty" xml:"os_version,omitempty"`
+	RawValue  string `json:"raw_value,omitempty" xml:"raw_value,omitempty"`
+}
+
+// productVersionPattern matches "Name/Version" tokens in a User-Agent
+// string. Browsers list several (e.g. "Mozilla/5.0 ... Chrome/120.0.0.0
+// Safari/537.36"); the last one is conventionally the most specific, so
+// parseUserAgent keeps that match.
+var productVersionPattern = regexp.MustCompile(`([A-Za-z][\w.-]*)/([\w.]+)`)
+
+// osCommentPattern extracts the parenthesized platform comment most
+// browsers place right after the leading product/version token, e.g.
+// "(Windows NT 10.0; Win64; x64)" or "(Macintosh; Intel Mac OS X 10_15_7)".
+var osCommentPattern = regexp.MustCompile(`\(([^)]*)\)`)
+
+// osPatterns maps a recognizable platform token to its display name and the
+// regexp that pulls its version out of the comment string.
+var osPatterns = []struct {
+	name    string
+	version *regexp.Regexp
+}{
+	{"Windows", regexp.MustCompile(`Windows NT ([\d.]+)`)},
+	{"Android", regexp.MustCompile(`Android ([\d.]+)`)},
+	{"iOS", regexp.MustCompile(`(?:iPhone )?OS ([\d_]+)`)},
+	{"macOS", regexp.MustCompile(`Mac OS X ([\d_.]+)`)},
+	{"Linux", regexp.MustCompile(`Linux`)},
+}
+
+// parseUserAgent does a minimal, dependency-free parse of a User-Agent
+// header: the most specific Product/Version token, plus an OS name and
+// version when the platform comment matches one of the common patterns.
+// Anything it can't confidently identify is left blank rather than guessed.
+func parseUserAgent(raw string) *UserAgentInfo {
+	if raw == "" {
+		return nil
+	}
+
+	info := &UserAgentInfo{RawValue: raw}
+
+	if matches := productVersionPattern.FindAllStringSubmatch(raw, -1); len(matches) > 0 {
+		last := matches[len(matches)-1]
+		info.Product, info.Version = last[1], last[2]
+	}
+
+	if comment := osCommentPattern.FindStringSubmatch(raw); comment != nil {
+		for _, p := range osPatterns {
+			m := p.version.FindStringSubmatch(comment[1])
+			if m == nil {
+				continue
+			}
+			info.OS = p.name
+			if len(m) > 1 {
+				info.OSVersion = strings.ReplaceAll(m[1], "_", ".")
+			}
+			break
+		}
+	}
+
+	return info
+}
+
+// wantUserAgent reports whether the User-Agent header should be parsed and
+// attached to the response: either the caller asked for it explicitly via
+// ?ua=true, or the response is going out as JSON anyway.
+func wantUserAgent(c *gin.Context, format responseFormat) bool {
+	if raw := c.Query("ua"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		return err == nil && enabled
+	}
+	return format == formatJSON
+}
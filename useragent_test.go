@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		product string
+		version string
+		os      string
+		osVer   string
+	}{
+		{
+			name:    "chrome on windows",
+			raw:     "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			product: "Safari",
+			version: "537.36",
+			os:      "Windows",
+			osVer:   "10.0",
+		},
+		{
+			name:    "safari on macos",
+			raw:     "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15",
+			product: "Safari",
+			version: "605.1.15",
+			os:      "macOS",
+			osVer:   "10.15.7",
+		},
+		{
+			name:    "mobile safari on ios",
+			raw:     "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+			product: "Mobile",
+			version: "15E148",
+			os:      "iOS",
+			osVer:   "17.0",
+		},
+		{
+			name:    "no parenthesized platform",
+			raw:     "curl/8.4.0",
+			product: "curl",
+			version: "8.4.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := parseUserAgent(tt.raw)
+			if info == nil {
+				t.Fatalf("parseUserAgent(%q) = nil", tt.raw)
+			}
+			if info.RawValue != tt.raw {
+				t.Errorf("RawValue = %q, want %q", info.RawValue, tt.raw)
+			}
+			if info.Product != tt.product || info.Version != tt.version {
+				t.Errorf("Product/Version = %q/%q, want %q/%q", info.Product, info.Version, tt.product, tt.version)
+			}
+			if info.OS != tt.os || info.OSVersion != tt.osVer {
+				t.Errorf("OS/OSVersion = %q/%q, want %q/%q", info.OS, info.OSVersion, tt.os, tt.osVer)
+			}
+		})
+	}
+
+	if parseUserAgent("") != nil {
+		t.Error("parseUserAgent(\"\") should return nil")
+	}
+}